@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"math/big"
 	"strings"
 
 	"github.com/lusingander/monkey/token"
@@ -10,6 +11,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // position of the first character of the node
+	End() token.Position // position immediately after the node
 }
 
 type Statement interface {
@@ -33,6 +36,20 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -53,6 +70,17 @@ func (s *LetStatement) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+func (s *LetStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *LetStatement) End() token.Position {
+	if s.Value != nil {
+		return s.Value.End()
+	}
+	return s.Name.End()
+}
+
 func (s *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(s.TokenLiteral() + " ")
@@ -76,6 +104,17 @@ func (s *ReturnStatement) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+func (s *ReturnStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *ReturnStatement) End() token.Position {
+	if s.ReturnValue != nil {
+		return s.ReturnValue.End()
+	}
+	return s.Token.End
+}
+
 func (s *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(s.TokenLiteral() + " ")
@@ -86,6 +125,188 @@ func (s *ReturnStatement) String() string {
 	return out.String()
 }
 
+type ExportStatement struct {
+	Token     token.Token // token.EXPORT
+	Statement *LetStatement
+}
+
+func (s *ExportStatement) statementNode() {}
+
+func (s *ExportStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *ExportStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *ExportStatement) End() token.Position {
+	if s.Statement != nil {
+		return s.Statement.End()
+	}
+	return s.Token.End
+}
+
+func (s *ExportStatement) String() string {
+	return s.TokenLiteral() + " " + s.Statement.String()
+}
+
+type WhileStatement struct {
+	Token     token.Token // token.WHILE
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (s *WhileStatement) statementNode() {}
+
+func (s *WhileStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *WhileStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *WhileStatement) End() token.Position {
+	if s.Body != nil {
+		return s.Body.End()
+	}
+	return s.Token.End
+}
+
+func (s *WhileStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("while")
+	out.WriteString(s.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(s.Body.String())
+	return out.String()
+}
+
+// ForStatement is a C-style for loop. Init, Condition and Post may be nil.
+type ForStatement struct {
+	Token     token.Token // token.FOR
+	Init      Statement
+	Condition Expression
+	Post      Statement
+	Body      *BlockStatement
+}
+
+func (s *ForStatement) statementNode() {}
+
+func (s *ForStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *ForStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *ForStatement) End() token.Position {
+	if s.Body != nil {
+		return s.Body.End()
+	}
+	return s.Token.End
+}
+
+func (s *ForStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("for (")
+	if s.Init != nil {
+		out.WriteString(strings.TrimSuffix(s.Init.String(), ";"))
+	}
+	out.WriteString("; ")
+	if s.Condition != nil {
+		out.WriteString(s.Condition.String())
+	}
+	out.WriteString("; ")
+	if s.Post != nil {
+		out.WriteString(strings.TrimSuffix(s.Post.String(), ";"))
+	}
+	out.WriteString(") ")
+	out.WriteString(s.Body.String())
+	return out.String()
+}
+
+type ForInStatement struct {
+	Token    token.Token // token.FOR
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (s *ForInStatement) statementNode() {}
+
+func (s *ForInStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *ForInStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *ForInStatement) End() token.Position {
+	if s.Body != nil {
+		return s.Body.End()
+	}
+	return s.Token.End
+}
+
+func (s *ForInStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("for (")
+	out.WriteString(s.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(s.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(s.Body.String())
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // token.BREAK
+}
+
+func (s *BreakStatement) statementNode() {}
+
+func (s *BreakStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *BreakStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *BreakStatement) End() token.Position {
+	return s.Token.End
+}
+
+func (s *BreakStatement) String() string {
+	return s.TokenLiteral() + ";"
+}
+
+type ContinueStatement struct {
+	Token token.Token // token.CONTINUE
+}
+
+func (s *ContinueStatement) statementNode() {}
+
+func (s *ContinueStatement) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *ContinueStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *ContinueStatement) End() token.Position {
+	return s.Token.End
+}
+
+func (s *ContinueStatement) String() string {
+	return s.TokenLiteral() + ";"
+}
+
 type ExpressionStatement struct {
 	Token      token.Token // First token of the expression
 	Expression Expression
@@ -97,6 +318,20 @@ func (s *ExpressionStatement) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+func (s *ExpressionStatement) Pos() token.Position {
+	if s.Expression != nil {
+		return s.Expression.Pos()
+	}
+	return s.Token.Pos
+}
+
+func (s *ExpressionStatement) End() token.Position {
+	if s.Expression != nil {
+		return s.Expression.End()
+	}
+	return s.Token.End
+}
+
 func (s *ExpressionStatement) String() string {
 	if s.Expression != nil {
 		return s.Expression.String()
@@ -115,6 +350,14 @@ func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
 
+func (i *Identifier) Pos() token.Position {
+	return i.Token.Pos
+}
+
+func (i *Identifier) End() token.Position {
+	return i.Token.End
+}
+
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -122,6 +365,7 @@ func (i *Identifier) String() string {
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // set instead of Value if the literal does not fit in an int64
 }
 
 func (l *IntegerLiteral) expressionNode() {}
@@ -130,6 +374,14 @@ func (l *IntegerLiteral) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+func (l *IntegerLiteral) Pos() token.Position {
+	return l.Token.Pos
+}
+
+func (l *IntegerLiteral) End() token.Position {
+	return l.Token.End
+}
+
 func (l *IntegerLiteral) String() string {
 	return l.Token.Literal
 }
@@ -145,6 +397,14 @@ func (l *FloatLiteral) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+func (l *FloatLiteral) Pos() token.Position {
+	return l.Token.Pos
+}
+
+func (l *FloatLiteral) End() token.Position {
+	return l.Token.End
+}
+
 func (l *FloatLiteral) String() string {
 	return l.Token.Literal
 }
@@ -160,6 +420,14 @@ func (b *Boolean) TokenLiteral() string {
 	return b.Token.Literal
 }
 
+func (b *Boolean) Pos() token.Position {
+	return b.Token.Pos
+}
+
+func (b *Boolean) End() token.Position {
+	return b.Token.End
+}
+
 func (b *Boolean) String() string {
 	return b.Token.Literal
 }
@@ -175,10 +443,53 @@ func (l *StringLiteral) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+func (l *StringLiteral) Pos() token.Position {
+	return l.Token.Pos
+}
+
+func (l *StringLiteral) End() token.Position {
+	return l.Token.End
+}
+
 func (l *StringLiteral) String() string {
 	return l.Token.Literal
 }
 
+// InterpolatedString is a string literal with embedded expressions, like
+// "a${x}b". Parts alternates between the literal text, as StringLiterals,
+// and the embedded expressions, starting and ending with literal text.
+type InterpolatedString struct {
+	Token token.Token // token.STRING_HEAD
+	Parts []Expression
+	Close token.Position // position after the closing quote
+}
+
+func (s *InterpolatedString) expressionNode() {}
+
+func (s *InterpolatedString) TokenLiteral() string {
+	return s.Token.Literal
+}
+
+func (s *InterpolatedString) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *InterpolatedString) End() token.Position {
+	return s.Close
+}
+
+func (s *InterpolatedString) String() string {
+	var out bytes.Buffer
+	for i, p := range s.Parts {
+		if i%2 == 0 {
+			out.WriteString(p.String())
+		} else {
+			out.WriteString("${" + p.String() + "}")
+		}
+	}
+	return out.String()
+}
+
 type PrefixExpression struct {
 	Token    token.Token // prefix token, e.g. "!"
 	Operator string
@@ -191,6 +502,17 @@ func (e *PrefixExpression) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+func (e *PrefixExpression) Pos() token.Position {
+	return e.Token.Pos
+}
+
+func (e *PrefixExpression) End() token.Position {
+	if e.Right != nil {
+		return e.Right.End()
+	}
+	return e.Token.End
+}
+
 func (e *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -213,6 +535,20 @@ func (e *InfixExpression) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+func (e *InfixExpression) Pos() token.Position {
+	if e.Left != nil {
+		return e.Left.Pos()
+	}
+	return e.Token.Pos
+}
+
+func (e *InfixExpression) End() token.Position {
+	if e.Right != nil {
+		return e.Right.End()
+	}
+	return e.Token.End
+}
+
 func (e *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -223,6 +559,45 @@ func (e *InfixExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression assigns to an identifier or an index expression. The
+// operator is "=" or a compound assignment operator such as "+=".
+type AssignExpression struct {
+	Token    token.Token // the operator token
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (e *AssignExpression) expressionNode() {}
+
+func (e *AssignExpression) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *AssignExpression) Pos() token.Position {
+	if e.Target != nil {
+		return e.Target.Pos()
+	}
+	return e.Token.Pos
+}
+
+func (e *AssignExpression) End() token.Position {
+	if e.Value != nil {
+		return e.Value.End()
+	}
+	return e.Token.End
+}
+
+func (e *AssignExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(e.Target.String())
+	out.WriteString(" " + e.Operator + " ")
+	out.WriteString(e.Value.String())
+	out.WriteString(")")
+	return out.String()
+}
+
 type IfExpression struct {
 	Token       token.Token // token.IF
 	Condition   Expression
@@ -236,6 +611,20 @@ func (e *IfExpression) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+func (e *IfExpression) Pos() token.Position {
+	return e.Token.Pos
+}
+
+func (e *IfExpression) End() token.Position {
+	if e.Alternative != nil {
+		return e.Alternative.End()
+	}
+	if e.Consequence != nil {
+		return e.Consequence.End()
+	}
+	return e.Token.End
+}
+
 func (e *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -250,8 +639,9 @@ func (e *IfExpression) String() string {
 }
 
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // token.LBRACE
 	Statements []Statement
+	Rbrace     token.Position // position after the closing brace
 }
 
 func (s *BlockStatement) statementNode() {}
@@ -260,6 +650,14 @@ func (s *BlockStatement) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+func (s *BlockStatement) Pos() token.Position {
+	return s.Token.Pos
+}
+
+func (s *BlockStatement) End() token.Position {
+	return s.Rbrace
+}
+
 func (s *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, stmt := range s.Statements {
@@ -280,6 +678,17 @@ func (l *FunctionLiteral) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+func (l *FunctionLiteral) Pos() token.Position {
+	return l.Token.Pos
+}
+
+func (l *FunctionLiteral) End() token.Position {
+	if l.Body != nil {
+		return l.Body.End()
+	}
+	return l.Token.End
+}
+
 func (l *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := make([]string, 0)
@@ -295,9 +704,10 @@ func (l *FunctionLiteral) String() string {
 }
 
 type CallExpression struct {
-	Token     token.Token
-	Function  Expression // Identifier or FunctionLiteral
+	Token     token.Token // token.LPAREN
+	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	Rparen    token.Position // position after the closing paren
 }
 
 func (e *CallExpression) expressionNode() {}
@@ -306,6 +716,17 @@ func (e *CallExpression) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+func (e *CallExpression) Pos() token.Position {
+	if e.Function != nil {
+		return e.Function.Pos()
+	}
+	return e.Token.Pos
+}
+
+func (e *CallExpression) End() token.Position {
+	return e.Rparen
+}
+
 func (e *CallExpression) String() string {
 	var out bytes.Buffer
 	args := make([]string, 0)
@@ -320,8 +741,9 @@ func (e *CallExpression) String() string {
 }
 
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // token.LBRACKET
 	Elements []Expression
+	Rbracket token.Position // position after the closing bracket
 }
 
 func (l *ArrayLiteral) expressionNode() {}
@@ -330,6 +752,14 @@ func (l *ArrayLiteral) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+func (l *ArrayLiteral) Pos() token.Position {
+	return l.Token.Pos
+}
+
+func (l *ArrayLiteral) End() token.Position {
+	return l.Rbracket
+}
+
 func (l *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elems := make([]string, 0)
@@ -343,8 +773,10 @@ func (l *ArrayLiteral) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token // token.LBRACE
+	Pairs  map[Expression]Expression
+	Keys   []Expression   // keys of Pairs in source order
+	Rbrace token.Position // position after the closing brace
 }
 
 func (l *HashLiteral) expressionNode() {}
@@ -353,11 +785,19 @@ func (l *HashLiteral) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+func (l *HashLiteral) Pos() token.Position {
+	return l.Token.Pos
+}
+
+func (l *HashLiteral) End() token.Position {
+	return l.Rbrace
+}
+
 func (l *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := make([]string, 0)
-	for k, v := range l.Pairs {
-		pairs = append(pairs, k.String()+":"+v.String())
+	for _, k := range l.Keys {
+		pairs = append(pairs, k.String()+":"+l.Pairs[k].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -365,10 +805,13 @@ func (l *HashLiteral) String() string {
 	return out.String()
 }
 
+// IndexExpression is either left[index] or left.name. The latter has a
+// token.DOT token and a StringLiteral index.
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // token.LBRACKET or token.DOT
+	Left     Expression
+	Index    Expression
+	Rbracket token.Position // position after the closing bracket or the name
 }
 
 func (e *IndexExpression) expressionNode() {}
@@ -377,16 +820,101 @@ func (e *IndexExpression) TokenLiteral() string {
 	return e.Token.Literal
 }
 
+func (e *IndexExpression) Pos() token.Position {
+	if e.Left != nil {
+		return e.Left.Pos()
+	}
+	return e.Token.Pos
+}
+
+func (e *IndexExpression) End() token.Position {
+	return e.Rbracket
+}
+
 func (e *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
 	out.WriteString(e.Left.String())
+	if e.Token.Type == token.DOT {
+		out.WriteString(".")
+		out.WriteString(e.Index.String())
+		out.WriteString(")")
+		return out.String()
+	}
 	out.WriteString("[")
 	out.WriteString(e.Index.String())
 	out.WriteString("])")
 	return out.String()
 }
 
+// SliceExpression is left[low:high]. Low and High are nil when omitted.
+type SliceExpression struct {
+	Token    token.Token // token.LBRACKET
+	Left     Expression
+	Low      Expression
+	High     Expression
+	Rbracket token.Position // position after the closing bracket
+}
+
+func (e *SliceExpression) expressionNode() {}
+
+func (e *SliceExpression) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *SliceExpression) Pos() token.Position {
+	if e.Left != nil {
+		return e.Left.Pos()
+	}
+	return e.Token.Pos
+}
+
+func (e *SliceExpression) End() token.Position {
+	return e.Rbracket
+}
+
+func (e *SliceExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(e.Left.String())
+	out.WriteString("[")
+	if e.Low != nil {
+		out.WriteString(e.Low.String())
+	}
+	out.WriteString(":")
+	if e.High != nil {
+		out.WriteString(e.High.String())
+	}
+	out.WriteString("])")
+	return out.String()
+}
+
+type ImportExpression struct {
+	Token token.Token // token.IMPORT
+	Path  *StringLiteral
+}
+
+func (e *ImportExpression) expressionNode() {}
+
+func (e *ImportExpression) TokenLiteral() string {
+	return e.Token.Literal
+}
+
+func (e *ImportExpression) Pos() token.Position {
+	return e.Token.Pos
+}
+
+func (e *ImportExpression) End() token.Position {
+	if e.Path != nil {
+		return e.Path.End()
+	}
+	return e.Token.End
+}
+
+func (e *ImportExpression) String() string {
+	return e.TokenLiteral() + " \"" + e.Path.String() + "\""
+}
+
 type MacroLiteral struct {
 	Token      token.Token // token.MACRO
 	Parameters []*Identifier
@@ -399,6 +927,17 @@ func (l *MacroLiteral) TokenLiteral() string {
 	return l.Token.Literal
 }
 
+func (l *MacroLiteral) Pos() token.Position {
+	return l.Token.Pos
+}
+
+func (l *MacroLiteral) End() token.Position {
+	if l.Body != nil {
+		return l.Body.End()
+	}
+	return l.Token.End
+}
+
 func (l *MacroLiteral) String() string {
 	var out bytes.Buffer
 	params := make([]string, 0)
@@ -57,6 +57,14 @@ func TestModify(t *testing.T) {
 			&IndexExpression{Left: one(), Index: one()},
 			&IndexExpression{Left: two(), Index: two()},
 		},
+		{
+			&SliceExpression{Left: one(), Low: one(), High: one()},
+			&SliceExpression{Left: two(), Low: two(), High: two()},
+		},
+		{
+			&SliceExpression{Left: one(), High: one()},
+			&SliceExpression{Left: two(), High: two()},
+		},
 		{
 			&IfExpression{
 				Condition: one(),
@@ -115,6 +123,70 @@ func TestModify(t *testing.T) {
 			&ArrayLiteral{Elements: []Expression{one(), one()}},
 			&ArrayLiteral{Elements: []Expression{two(), two()}},
 		},
+		{
+			&InterpolatedString{Parts: []Expression{&StringLiteral{Value: "a"}, one(), &StringLiteral{}}},
+			&InterpolatedString{Parts: []Expression{&StringLiteral{Value: "a"}, two(), &StringLiteral{}}},
+		},
+		{
+			&WhileStatement{
+				Condition: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&WhileStatement{
+				Condition: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&ForStatement{
+				Init:      &LetStatement{Value: one()},
+				Condition: one(),
+				Post:      &ExpressionStatement{Expression: one()},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&ForStatement{
+				Init:      &LetStatement{Value: two()},
+				Condition: two(),
+				Post:      &ExpressionStatement{Expression: two()},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&ForInStatement{
+				Variable: &Identifier{Value: "x"},
+				Iterable: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&ForInStatement{
+				Variable: &Identifier{Value: "x"},
+				Iterable: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -126,15 +198,23 @@ func TestModify(t *testing.T) {
 		}
 	}
 
+	k1, k2 := one(), one()
 	hashLiteral := &HashLiteral{
 		Pairs: map[Expression]Expression{
-			one(): one(),
-			one(): one(),
+			k1: one(),
+			k2: one(),
 		},
+		Keys: []Expression{k1, k2},
 	}
 
 	Modify(hashLiteral, turnOneIntoTwo)
 
+	for _, k := range hashLiteral.Keys {
+		if _, ok := hashLiteral.Pairs[k]; !ok {
+			t.Errorf("key %s of Keys not in Pairs", k)
+		}
+	}
+
 	for k, v := range hashLiteral.Pairs {
 		k, _ := k.(*IntegerLiteral)
 		if k.Value != 2 {
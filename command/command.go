@@ -5,42 +5,124 @@ import (
 	"io/ioutil"
 	"os"
 	"os/user"
+	"path/filepath"
 
+	"github.com/lusingander/monkey/evaluator"
 	"github.com/lusingander/monkey/repl"
 	"github.com/urfave/cli/v2"
 )
 
 var in, out = os.Stdin, os.Stdout
 
+var checkedFlag = &cli.BoolFlag{
+	Name:  "checked",
+	Usage: "report integer overflow as an error instead of promoting to a big integer",
+}
+
+var strictFlag = &cli.BoolFlag{
+	Name:  "strict",
+	Usage: "report an out-of-range index or slice bound as an error instead of returning null or clamping",
+}
+
 var ReplCommand = &cli.Command{
 	Name:  "repl",
 	Usage: "Start REPL",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "no-color",
+			Usage: "do not color the results (also disabled by the NO_COLOR environment variable)",
+		},
+		checkedFlag,
+		strictFlag,
+	},
 	Action: func(c *cli.Context) error {
+		evaluator.CheckOverflow = c.Bool("checked")
+		evaluator.StrictIndex = c.Bool("strict")
+
 		user, err := user.Current()
 		if err != nil {
 			return err
 		}
 
 		fmt.Fprintf(out, "Hello %s! This is the Monkey programming language!\n", user.Username)
-		fmt.Fprintf(out, "Feel free to type in commands\n")
+		fmt.Fprintf(out, "Feel free to type in commands, or :help for REPL commands\n")
 
-		repl.Start(in, out)
-		return nil
+		if !repl.IsTerminal(in) {
+			return exitError("", repl.Start(in, out))
+		}
+		color := repl.IsTerminal(out) && !c.Bool("no-color") && os.Getenv("NO_COLOR") == ""
+		code, err := repl.StartTerminal(out, historyFile(), color)
+		if err != nil {
+			return err
+		}
+		return exitError("", code)
 	},
 }
 
 var RunCommand = &cli.Command{
-	Name:  "run",
-	Usage: "Run Monkey program",
+	Name:      "run",
+	Usage:     "Run Monkey program",
+	ArgsUsage: "[FILE | -] [ARGS...]",
+	Description: `Runs the program in FILE, or the program read from standard input if FILE
+is - or is left out while the input is not a terminal. ARGS are available to
+the program as the array of strings args. The vm engine does not support
+modules, so a program that uses import or export must run with the eval engine.
+
+Exit status:
+  0   the program ran to the end
+  1   runtime error
+  2   usage error, such as a missing or unreadable FILE
+  3   parse or compile error
+A program that calls exit(code) ends with code instead.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "engine",
+			Usage: "execution engine: eval (tree-walking evaluator) or vm (bytecode virtual machine)",
+			Value: engineEval,
+		},
+		checkedFlag,
+		strictFlag,
+	},
 	Action: func(c *cli.Context) error {
-		if c.NArg() != 1 {
-			return cli.Exit("File not specified", 1)
+		filename, args := c.Args().First(), c.Args().Tail()
+		if c.NArg() == 0 {
+			if repl.IsTerminal(in) {
+				return exitError("File not specified", ExitUsageError)
+			}
+			filename = "-"
 		}
-		filename := c.Args().First()
-		content, err := ioutil.ReadFile(filename)
+		content, err := readProgram(filename)
 		if err != nil {
-			return err
+			return exitError(err.Error(), ExitUsageError)
 		}
-		return run(string(content))
+		if filename == "-" {
+			filename = stdinName
+		}
+		evaluator.CheckOverflow = c.Bool("checked")
+		evaluator.StrictIndex = c.Bool("strict")
+		return run(filename, string(content), c.String("engine"), args)
 	},
 }
+
+// stdinName is the file name in the positions of a program read from the
+// standard input.
+const stdinName = "<stdin>"
+
+// readProgram reads the file filename, or the standard input if filename
+// is "-".
+func readProgram(filename string) ([]byte, error) {
+	if filename == "-" {
+		return ioutil.ReadAll(in)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// historyFile returns the path of the REPL history file in the home
+// directory, or "" if there is none.
+func historyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".monkey_history")
+}
@@ -2,16 +2,50 @@ package command
 
 import (
 	"bytes"
-	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/lusingander/monkey/compiler"
 	"github.com/lusingander/monkey/evaluator"
 	"github.com/lusingander/monkey/lexer"
 	"github.com/lusingander/monkey/object"
 	"github.com/lusingander/monkey/parser"
+	"github.com/lusingander/monkey/vm"
+	"github.com/urfave/cli/v2"
 )
 
-func run(input string) error {
-	l := lexer.New(input)
+const (
+	engineEval = "eval"
+	engineVM   = "vm"
+)
+
+// Exit statuses of the monkey command. A program that calls exit ends with
+// the status passed to it instead.
+const (
+	ExitRuntimeError = 1
+	ExitUsageError   = 2
+	ExitSyntaxError  = 3
+)
+
+// run runs the program in input with args bound to the global args. The
+// error it returns carries the exit status.
+func run(filename, input, engine string, args []string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if exit, ok := r.(evaluator.Exit); ok {
+				err = exitError("", exit.Code)
+				return
+			}
+			// report a Go panic like any other runtime error
+			err = buildEvaluateError(evaluator.PanicError(r))
+		}
+	}()
+
+	if filename != stdinName {
+		defer evaluator.EnterFile(filename)()
+	}
+
+	l := lexer.NewFile(filename, input)
 	p := parser.New(l)
 
 	program := p.ParseProgram()
@@ -19,13 +53,38 @@ func run(input string) error {
 		return buildParserError(p.Errors())
 	}
 
-	env := object.NewEnvironment()
 	macroEnv := object.NewEnvironment()
 
 	evaluator.DefineMacros(program, macroEnv)
 	expanded := evaluator.ExpandMacros(program, macroEnv)
 
-	evaluated := evaluator.Eval(expanded, env)
+	argsArray := &object.Array{Elements: make([]object.Object, len(args))}
+	for i, arg := range args {
+		argsArray.Elements[i] = &object.String{Value: arg}
+	}
+
+	var evaluated object.Object
+	switch engine {
+	case engineEval:
+		env := object.NewEnvironment()
+		env.Set("args", argsArray)
+		evaluated = evaluator.Eval(expanded, env)
+	case engineVM:
+		symbolTable := compiler.NewSymbolTable()
+		for i, name := range evaluator.BuiltinNames() {
+			symbolTable.DefineBuiltin(i, name)
+		}
+		globals := make([]object.Object, vm.GlobalsSize)
+		globals[symbolTable.Define("args").Index] = argsArray
+
+		c := compiler.NewWithState(symbolTable, []object.Object{})
+		if err := c.Compile(expanded); err != nil {
+			return buildCompileError(err)
+		}
+		evaluated = vm.NewWithGlobals(c.Bytecode(), globals).Run()
+	default:
+		return exitError(fmt.Sprintf("unknown engine: %s", engine), ExitUsageError)
+	}
 	if errObj, ok := evaluated.(*object.Error); ok {
 		return buildEvaluateError(errObj)
 	}
@@ -34,15 +93,27 @@ func run(input string) error {
 
 func buildParserError(errs []string) error {
 	var out bytes.Buffer
-	out.WriteString("ERROR:\n")
+	out.WriteString("ERROR:")
 	for _, msg := range errs {
-		out.WriteString("\t")
+		out.WriteString("\n\t")
 		out.WriteString(msg)
-		out.WriteString("\n")
 	}
-	return errors.New(out.String())
+	return exitError(out.String(), ExitSyntaxError)
+}
+
+func buildCompileError(err error) error {
+	return exitError("ERROR: "+err.Error(), ExitSyntaxError)
 }
 
 func buildEvaluateError(err *object.Error) error {
-	return errors.New(err.Inspect())
+	return exitError(strings.TrimSuffix(err.Inspect()+"\n"+err.Traceback(), "\n"), ExitRuntimeError)
+}
+
+// exitError returns an error that makes the command print message, if it
+// is not empty, and end with code as its exit status.
+func exitError(message string, code int) error {
+	if code == 0 {
+		return nil
+	}
+	return cli.Exit(message, code)
 }
@@ -0,0 +1,630 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/lusingander/monkey/ast"
+	"github.com/lusingander/monkey/code"
+	"github.com/lusingander/monkey/evaluator"
+	"github.com/lusingander/monkey/lexer"
+	"github.com/lusingander/monkey/object"
+	"github.com/lusingander/monkey/parser"
+)
+
+type compiledFunction struct {
+	instructions []code.Instructions
+	captures     []object.Capture
+}
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1; !true",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 && 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),       // 0000
+				code.Make(code.OpJumpNotTruthy, 14), // 0003
+				code.Make(code.OpConstant, 1),       // 0006
+				code.Make(code.OpBang),              // 0009
+				code.Make(code.OpBang),              // 0010
+				code.Make(code.OpJump, 15),          // 0011
+				code.Make(code.OpFalse),             // 0014
+				code.Make(code.OpPop),               // 0015
+			},
+		},
+		{
+			input:             "1 || 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),       // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0003
+				code.Make(code.OpTrue),              // 0006
+				code.Make(code.OpJump, 15),          // 0007
+				code.Make(code.OpConstant, 1),       // 0010
+				code.Make(code.OpBang),              // 0013
+				code.Make(code.OpBang),              // 0014
+				code.Make(code.OpPop),               // 0015
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a${1}b${2}"`,
+			expectedConstants: []interface{}{"a", 1, "b", 2, ""},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpInterpolate, 5),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSliceExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, 2][1:2]",
+			expectedConstants: []interface{}{1, 2, 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"ab"[:1]`,
+			expectedConstants: []interface{}{"ab", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSlice),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpJump, 11),          // 0007
+				code.Make(code.OpNull),              // 0010
+				code.Make(code.OpPop),               // 0011
+				code.Make(code.OpConstant, 1),       // 0012
+				code.Make(code.OpPop),               // 0015
+			},
+		},
+		{
+			input:             "if (true) { let x = 10; }",
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 14), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpSetGlobal, 0),      // 0007
+				code.Make(code.OpNull),              // 0010
+				code.Make(code.OpJump, 15),          // 0011
+				code.Make(code.OpNull),              // 0014
+				code.Make(code.OpPop),               // 0015
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x += 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = []; a[0] *= 2;",
+			expectedConstants: []interface{}{0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDup2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { fn() { a = 1 } }",
+			expectedConstants: []interface{}{
+				1,
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpSetFree, 0),
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpReturnValue),
+					},
+					captures: []object.Capture{
+						{Kind: object.CaptureLocal, Index: 0},
+					},
+				},
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpClosure, 1),
+						code.Make(code.OpReturnValue),
+					},
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { break; } 1;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpJump, 10),          // 0004
+				code.Make(code.OpJump, 0),           // 0007
+				code.Make(code.OpConstant, 0),       // 0010
+				code.Make(code.OpPop),               // 0013
+			},
+		},
+		{
+			input:             "for (let i = 0; ; i) { continue; }",
+			expectedConstants: []interface{}{0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),  // 0000
+				code.Make(code.OpSetGlobal, 0), // 0003
+				code.Make(code.OpJump, 9),      // 0006
+				code.Make(code.OpGetGlobal, 0), // 0009
+				code.Make(code.OpPop),          // 0012
+				code.Make(code.OpJump, 6),      // 0013
+			},
+		},
+		{
+			input:             "for (x in []) { x; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),     // 0000
+				code.Make(code.OpIter),         // 0003
+				code.Make(code.OpSetGlobal, 0), // 0004
+				code.Make(code.OpGetGlobal, 0), // 0007
+				code.Make(code.OpIterNext, 23), // 0010
+				code.Make(code.OpSetGlobal, 1), // 0013
+				code.Make(code.OpGetGlobal, 1), // 0016
+				code.Make(code.OpPop),          // 0019
+				code.Make(code.OpJump, 7),      // 0020
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; let two = one; let one = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let f = fn(a) { let b = a; b }; f(1); len([]);",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, builtinIndex("len")),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+fn(a) {
+  fn(b) {
+    fn(c) {
+      a + b + c
+    }
+  }
+};`,
+			expectedConstants: []interface{}{
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpGetFree, 1),
+						code.Make(code.OpAdd),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpAdd),
+						code.Make(code.OpReturnValue),
+					},
+					captures: []object.Capture{
+						{Kind: object.CaptureFree, Index: 0},
+						{Kind: object.CaptureLocal, Index: 0},
+					},
+				},
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpClosure, 0),
+						code.Make(code.OpReturnValue),
+					},
+					captures: []object.Capture{
+						{Kind: object.CaptureLocal, Index: 0},
+					},
+				},
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpClosure, 1),
+						code.Make(code.OpReturnValue),
+					},
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+let global = 1;
+fn() {
+  let unused = 2;
+  let used = 3;
+  fn() { global + used }
+};`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				3,
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetGlobal, 0),
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpAdd),
+						code.Make(code.OpReturnValue),
+					},
+					captures: []object.Capture{
+						{Kind: object.CaptureLocal, Index: 1},
+					},
+				},
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpConstant, 1),
+						code.Make(code.OpSetLocal, 0),
+						code.Make(code.OpConstant, 2),
+						code.Make(code.OpSetLocal, 1),
+						code.Make(code.OpClosure, 3),
+						code.Make(code.OpReturnValue),
+					},
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 4),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+let wrapper = fn() {
+  let countDown = fn(x) { fn() { countDown(x - 1) } };
+  countDown(1);
+};`,
+			expectedConstants: []interface{}{
+				1,
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpGetFree, 1),
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpSub),
+						code.Make(code.OpCall, 1),
+						code.Make(code.OpReturnValue),
+					},
+					captures: []object.Capture{
+						{Kind: object.CaptureSelf},
+						{Kind: object.CaptureLocal, Index: 0},
+					},
+				},
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpClosure, 1),
+						code.Make(code.OpReturnValue),
+					},
+				},
+				1,
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpClosure, 2),
+						code.Make(code.OpSetLocal, 0),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpConstant, 3),
+						code.Make(code.OpCall, 1),
+						code.Make(code.OpReturnValue),
+					},
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 4),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;\nfoo + a", "test.monkey:2:1: identifier not found: foo"},
+		{`let lib = import "lib.monkey";`, "test.monkey:1:11: modules are not supported by the vm engine"},
+		{"export let a = 1;", "test.monkey:1:1: modules are not supported by the vm engine"},
+		{"x = 1;", "test.monkey:1:1: cannot assign to undefined identifier: x"},
+		{"len = 1;", "test.monkey:1:1: cannot assign to undefined identifier: len"},
+		{"if (true) { break; }", "test.monkey:1:13: break outside of a loop"},
+		{"while (true) { fn() { continue; }; }", "test.monkey:1:23: continue outside of a loop"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Errorf("expected compile error: input=%q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compile error: want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func builtinIndex(name string) int {
+	for i, n := range evaluator.BuiltinNames() {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		testInstructions(t, tt.expectedInstructions, bytecode.Instructions)
+		testConstants(t, tt.expectedConstants, bytecode.Constants)
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.NewFile("test.monkey", input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testInstructions(t *testing.T, expected []code.Instructions, actual code.Instructions) {
+	t.Helper()
+
+	concatted := concatInstructions(expected)
+
+	if len(actual) != len(concatted) {
+		t.Fatalf("wrong instructions length:\nwant=%q\ngot=%q", concatted, actual)
+	}
+
+	for i, ins := range concatted {
+		if actual[i] != ins {
+			t.Fatalf("wrong instruction at %d:\nwant=%q\ngot=%q", i, concatted, actual)
+		}
+	}
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func testConstants(t *testing.T, expected []interface{}, actual []object.Object) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("wrong number of constants: want=%d, got=%d", len(expected), len(actual))
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			result, ok := actual[i].(*object.Integer)
+			if !ok {
+				t.Fatalf("constant %d is not Integer: got=%T (%+v)", i, actual[i], actual[i])
+			}
+			if result.Value != int64(constant) {
+				t.Fatalf("constant %d has wrong value: want=%d, got=%d", i, constant, result.Value)
+			}
+		case string:
+			result, ok := actual[i].(*object.String)
+			if !ok {
+				t.Fatalf("constant %d is not String: got=%T (%+v)", i, actual[i], actual[i])
+			}
+			if result.Value != constant {
+				t.Fatalf("constant %d has wrong value: want=%q, got=%q", i, constant, result.Value)
+			}
+		case []code.Instructions:
+			testConstants(t, []interface{}{compiledFunction{instructions: constant}}, actual[i:i+1])
+		case compiledFunction:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				t.Fatalf("constant %d is not CompiledFunction: got=%T (%+v)", i, actual[i], actual[i])
+			}
+			testInstructions(t, constant.instructions, fn.Instructions)
+			if len(fn.Captures) != len(constant.captures) {
+				t.Fatalf("constant %d has wrong number of captures: want=%d, got=%d", i, len(constant.captures), len(fn.Captures))
+			}
+			for j, c := range constant.captures {
+				if fn.Captures[j] != c {
+					t.Fatalf("constant %d has wrong capture %d: want=%+v, got=%+v", i, j, c, fn.Captures[j])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	// FreeSymbols are the symbols of the enclosing scopes referenced from
+	// this scope, in the order their values have to be captured.
+	FreeSymbols []Symbol
+
+	store          map[string]Symbol
+	numDefinitions int
+
+	// function is the table of the enclosing function, which owns the
+	// slots of the symbols. It is the table itself unless it is a block.
+	function *SymbolTable
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := &SymbolTable{
+		store: make(map[string]Symbol),
+	}
+	s.function = s
+	return s
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockSymbolTable returns a table for a block, such as a loop, of the
+// function of outer. Its names shadow those of outer, but its symbols take
+// slots of the function and are not captured as free symbols.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.function = outer.function
+	return s
+}
+
+// Define binds name in the table. Redefining a name in the same table
+// reuses its slot, just like let overwrites a binding in an Environment.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+	symbol := Symbol{
+		Name:  name,
+		Index: s.function.numDefinitions,
+	}
+	if s.function.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+	s.store[name] = symbol
+	s.function.numDefinitions++
+	return symbol
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{
+		Name:  name,
+		Scope: BuiltinScope,
+		Index: index,
+	}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName binds the name of the function being compiled, so
+// that the function can refer to itself without capturing its own binding.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{
+		Name:  name,
+		Scope: FunctionScope,
+		Index: 0,
+	}
+	s.store[name] = symbol
+	return symbol
+}
+
+// Resolve looks up name in this and the enclosing tables. Locals of an
+// enclosing function are turned into free symbols of this table.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok || s.function != s {
+		return symbol, ok
+	}
+	if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+	return s.defineFree(symbol), true
+}
+
+// ResolveBinding is like Resolve, except that the name of the function being
+// compiled resolves to the variable the function is bound to, which is what
+// an assignment to the name changes.
+func (s *SymbolTable) ResolveBinding(name string) (Symbol, bool) {
+	symbol, ok := s.Resolve(name)
+	if !ok || symbol.Scope != FunctionScope {
+		return symbol, ok
+	}
+	fn := s.function
+	symbol, ok = fn.Outer.Resolve(name)
+	if !ok || symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+	return fn.defineFree(symbol), true
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	symbol := Symbol{
+		Name:  original.Name,
+		Scope: FreeScope,
+		Index: len(s.FreeSymbols) - 1,
+	}
+	s.store[original.Name] = symbol
+	return symbol
+}
@@ -0,0 +1,161 @@
+package compiler
+
+import "testing"
+
+func TestDefineResolve(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	a := global.Define("a")
+	b := global.Define("b")
+
+	local := NewEnclosedSymbolTable(global)
+	c := local.Define("c")
+	d := local.Define("d")
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{global, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{global, "b", Symbol{Name: "b", Scope: GlobalScope, Index: 1}},
+		{local, "a", a},
+		{local, "b", b},
+		{local, "c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+		{local, "d", Symbol{Name: "d", Scope: LocalScope, Index: 1}},
+		{local, "len", Symbol{Name: "len", Scope: BuiltinScope, Index: 0}},
+	}
+
+	if c != tests[4].expected || d != tests[5].expected {
+		t.Fatalf("wrong local definitions: got=%+v, %+v", c, d)
+	}
+
+	for _, tt := range tests {
+		result, ok := tt.table.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestRedefine(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+
+	a1 := global.Define("a")
+	a2 := global.Define("a")
+	if a1 != a2 {
+		t.Errorf("redefinition should reuse the slot: got=%+v, %+v", a1, a2)
+	}
+
+	shadow := global.Define("len")
+	expected := Symbol{Name: "len", Scope: GlobalScope, Index: 1}
+	if shadow != expected {
+		t.Errorf("builtin should be shadowed: want=%+v, got=%+v", expected, shadow)
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	first := NewEnclosedSymbolTable(global)
+	first.DefineFunctionName("f")
+	first.Define("b")
+
+	second := NewEnclosedSymbolTable(first)
+	second.Define("c")
+
+	tests := []struct {
+		name     string
+		expected Symbol
+	}{
+		{"a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{"b", Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+		{"c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+		{"f", Symbol{Name: "f", Scope: FreeScope, Index: 1}},
+		{"b", Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		result, ok := second.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, result)
+		}
+	}
+
+	expectedFree := []Symbol{
+		{Name: "b", Scope: LocalScope, Index: 0},
+		{Name: "f", Scope: FunctionScope, Index: 0},
+	}
+	if len(second.FreeSymbols) != len(expectedFree) {
+		t.Fatalf("wrong number of free symbols: want=%d, got=%d", len(expectedFree), len(second.FreeSymbols))
+	}
+	for i, sym := range expectedFree {
+		if second.FreeSymbols[i] != sym {
+			t.Errorf("wrong free symbol: want=%+v, got=%+v", sym, second.FreeSymbols[i])
+		}
+	}
+
+	if _, ok := second.Resolve("undefined"); ok {
+		t.Errorf("name undefined resolved")
+	}
+}
+
+func TestBlockSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	globalBlock := NewBlockSymbolTable(global)
+	globalBlock.Define("a")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+	block := NewBlockSymbolTable(local)
+	block.Define("b")
+	block.Define("c")
+	inner := NewEnclosedSymbolTable(block)
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{globalBlock, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 1}},
+		{global, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{block, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{block, "b", Symbol{Name: "b", Scope: LocalScope, Index: 1}},
+		{block, "c", Symbol{Name: "c", Scope: LocalScope, Index: 2}},
+		{local, "b", Symbol{Name: "b", Scope: LocalScope, Index: 0}},
+		{inner, "c", Symbol{Name: "c", Scope: FreeScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		result, ok := tt.table.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, result)
+		}
+	}
+
+	if local.numDefinitions != 3 {
+		t.Errorf("block symbols should take slots of the function: want=3, got=%d", local.numDefinitions)
+	}
+	if _, ok := local.Resolve("c"); ok {
+		t.Errorf("name c resolved outside of its block")
+	}
+	if len(block.FreeSymbols) != 0 {
+		t.Errorf("block should not capture free symbols: got=%+v", block.FreeSymbols)
+	}
+}
@@ -0,0 +1,167 @@
+package evaluator
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/lusingander/monkey/object"
+)
+
+// maxBigIntBits bounds the size of the results of ** and <<, which could
+// otherwise exhaust the memory.
+const maxBigIntBits = 1 << 24
+
+func evalBigIntInfixExpression(operator string, left, right object.Object) object.Object {
+	lv := toBigInt(left)
+	rv := toBigInt(right)
+	switch operator {
+	case "+":
+		return newInteger(new(big.Int).Add(lv, rv))
+	case "-":
+		return newInteger(new(big.Int).Sub(lv, rv))
+	case "*":
+		return newInteger(new(big.Int).Mul(lv, rv))
+	case "/", "%":
+		if rv.Sign() == 0 {
+			return newError("division by zero: %s %s %s", lv, operator, rv)
+		}
+		// truncated division, like the int64 operators
+		if operator == "/" {
+			return newInteger(new(big.Int).Quo(lv, rv))
+		}
+		return newInteger(new(big.Int).Rem(lv, rv))
+	case "**":
+		if rv.Sign() < 0 {
+			return &object.Float{Value: math.Pow(toFloat(left), toFloat(right))}
+		}
+		if lv.CmpAbs(big.NewInt(1)) > 0 && (!rv.IsInt64() || int64(lv.BitLen()-1)*rv.Int64() > maxBigIntBits) {
+			return newError("integer too large: %s ** %s", lv, rv)
+		}
+		return newInteger(new(big.Int).Exp(lv, rv, nil))
+	case "&":
+		return newInteger(new(big.Int).And(lv, rv))
+	case "|":
+		return newInteger(new(big.Int).Or(lv, rv))
+	case "^":
+		return newInteger(new(big.Int).Xor(lv, rv))
+	case "<<", ">>":
+		if rv.Sign() < 0 {
+			return newError("negative shift count: %s", rv)
+		}
+		if operator == ">>" {
+			if !rv.IsInt64() || rv.Int64() > int64(lv.BitLen()) {
+				// every bit is shifted out, only the sign remains
+				if lv.Sign() < 0 {
+					return &object.Integer{Value: -1}
+				}
+				return &object.Integer{Value: 0}
+			}
+			return newInteger(new(big.Int).Rsh(lv, uint(rv.Int64())))
+		}
+		if lv.Sign() == 0 {
+			return newInteger(lv)
+		}
+		if !rv.IsInt64() || int64(lv.BitLen())+rv.Int64() > maxBigIntBits {
+			return newError("integer too large: %s << %s", lv, rv)
+		}
+		return newInteger(new(big.Int).Lsh(lv, uint(rv.Int64())))
+	case "<":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) != 0)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// newInteger returns an Integer if n fits in an int64, and a BigInt
+// otherwise.
+func newInteger(n *big.Int) object.Object {
+	if n.IsInt64() {
+		return &object.Integer{Value: n.Int64()}
+	}
+	return &object.BigInt{Value: n}
+}
+
+func isInteger(obj object.Object) bool {
+	t := obj.Type()
+	return t == object.IntegerObj || t == object.BigIntObj
+}
+
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FloatObj
+}
+
+// toBigInt converts an Integer or BigInt. The result must not be modified.
+func toBigInt(obj object.Object) *big.Int {
+	switch obj := obj.(type) {
+	case *object.BigInt:
+		return obj.Value
+	case *object.Integer:
+		return big.NewInt(obj.Value)
+	}
+	return nil
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Float:
+		return obj.Value
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	}
+	return 0
+}
+
+// compareNumbers compares two numbers exactly, which float64 cannot do for
+// an Integer or BigInt and a Float. It reports false if either is NaN.
+func compareNumbers(left, right object.Object) (int, bool) {
+	lv, lok := toBigFloat(left)
+	rv, rok := toBigFloat(right)
+	if !lok || !rok {
+		return 0, false
+	}
+	return lv.Cmp(rv), true
+}
+
+func toBigFloat(obj object.Object) (*big.Float, bool) {
+	if f, ok := obj.(*object.Float); ok {
+		if math.IsNaN(f.Value) {
+			return nil, false
+		}
+		return big.NewFloat(f.Value), true
+	}
+	// the precision of SetInt is enough to hold the integer exactly
+	return new(big.Float).SetInt(toBigInt(obj)), true
+}
+
+// evalComparison turns the result c of a three-way comparison into the
+// result of operator. It reports false if operator is not a comparison.
+func evalComparison(operator string, c int) (object.Object, bool) {
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(c < 0), true
+	case ">":
+		return nativeBoolToBooleanObject(c > 0), true
+	case "<=":
+		return nativeBoolToBooleanObject(c <= 0), true
+	case ">=":
+		return nativeBoolToBooleanObject(c >= 0), true
+	case "==":
+		return nativeBoolToBooleanObject(c == 0), true
+	case "!=":
+		return nativeBoolToBooleanObject(c != 0), true
+	}
+	return nil, false
+}
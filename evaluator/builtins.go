@@ -2,7 +2,9 @@ package evaluator
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/lusingander/monkey/object"
 )
@@ -11,11 +13,70 @@ var builtins = map[string]*object.Builtin{
 	"puts":    {Fn: builtinPuts},
 	"print":   {Fn: builtinPrint},
 	"println": {Fn: builtinPrintln},
+	"exit":    {Fn: builtinExit},
 	"len":     {Fn: builtinLen},
 	"first":   {Fn: builtinFirst},
 	"last":    {Fn: builtinLast},
 	"rest":    {Fn: builtinRest},
 	"push":    {Fn: builtinPush},
+
+	"byte_len":   {Fn: builtinByteLen},
+	"bytes":      {Fn: builtinBytes},
+	"from_bytes": {Fn: builtinFromBytes},
+
+	"split":       {Fn: builtinSplit},
+	"join":        {Fn: builtinJoin},
+	"trim":        {Fn: builtinTrim},
+	"upper":       {Fn: builtinUpper},
+	"lower":       {Fn: builtinLower},
+	"contains":    {Fn: builtinContains},
+	"index_of":    {Fn: builtinIndexOf},
+	"replace":     {Fn: builtinReplace},
+	"starts_with": {Fn: builtinStartsWith},
+	"ends_with":   {Fn: builtinEndsWith},
+	"repeat":      {Fn: builtinRepeat},
+	"substr":      {Fn: builtinSubstr},
+	"format":      {Fn: builtinFormat},
+
+	"map":     {CallbackFn: builtinMap},
+	"filter":  {CallbackFn: builtinFilter},
+	"reduce":  {CallbackFn: builtinReduce},
+	"each":    {CallbackFn: builtinEach},
+	"find":    {CallbackFn: builtinFind},
+	"any":     {CallbackFn: builtinAny},
+	"all":     {CallbackFn: builtinAll},
+	"sort":    {CallbackFn: builtinSort},
+	"reverse": {Fn: builtinReverse},
+	"zip":     {Fn: builtinZip},
+	"range":   {Fn: builtinRange},
+	"flatten": {Fn: builtinFlatten},
+	"concat":  {Fn: builtinConcat},
+
+	"keys":    {Fn: builtinKeys},
+	"values":  {Fn: builtinValues},
+	"entries": {Fn: builtinEntries},
+	"has_key": {Fn: builtinHasKey},
+	"delete":  {Fn: builtinDelete},
+	"merge":   {Fn: builtinMerge},
+	"hash":    {Fn: builtinHash},
+}
+
+// maxRangeLen bounds the length of the arrays built by range.
+const maxRangeLen = 1 << 26
+
+// BuiltinNames returns the names of the builtin functions in sorted order.
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
 }
 
 func builtinPuts(args ...object.Object) object.Object {
@@ -39,15 +100,40 @@ func builtinPrintln(args ...object.Object) object.Object {
 	return builtinPrint(args...)
 }
 
+// Exit is the value the exit builtin panics with to end the program with
+// Code as its exit status.
+type Exit struct {
+	Code int
+}
+
+func builtinExit(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 0, 1); err != nil {
+		return err
+	}
+	code := int64(0)
+	if len(args) == 1 {
+		var err *object.Error
+		if code, err = integerArg("exit", args, 0); err != nil {
+			return err
+		}
+		if code < 0 || code > 255 {
+			return newError("exit code out of range: %d", code)
+		}
+	}
+	panic(Exit{Code: int(code)})
+}
+
 func builtinLen(args ...object.Object) object.Object {
 	if len(args) != 1 {
 		return newError("wrong number of arguments: want=1, got=%d", len(args))
 	}
 	switch arg := args[0].(type) {
 	case *object.String:
-		return &object.Integer{Value: int64(len(arg.Value))}
+		return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 	case *object.Array:
 		return &object.Integer{Value: int64(len(arg.Elements))}
+	case *object.Hash:
+		return &object.Integer{Value: int64(arg.Len())}
 	default:
 		return newError("argument to 'len' not supported: got=%s", arg.Type())
 	}
@@ -117,3 +203,589 @@ func builtinPush(args ...object.Object) object.Object {
 		return newError("argument to 'push' not supported: got=%s", arg.Type())
 	}
 }
+
+// byte_len, bytes and from_bytes work on the UTF-8 encoding of strings,
+// where len and indexing work on characters.
+
+func builtinByteLen(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments: want=1, got=%d", len(args))
+	}
+	switch arg := args[0].(type) {
+	case *object.String:
+		return &object.Integer{Value: int64(len(arg.Value))}
+	default:
+		return newError("argument to 'byte_len' not supported: got=%s", arg.Type())
+	}
+}
+
+func builtinBytes(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments: want=1, got=%d", len(args))
+	}
+	switch arg := args[0].(type) {
+	case *object.String:
+		elems := make([]object.Object, len(arg.Value))
+		for i := 0; i < len(arg.Value); i++ {
+			elems[i] = &object.Integer{Value: int64(arg.Value[i])}
+		}
+		return &object.Array{Elements: elems}
+	default:
+		return newError("argument to 'bytes' not supported: got=%s", arg.Type())
+	}
+}
+
+func builtinFromBytes(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments: want=1, got=%d", len(args))
+	}
+	arg, ok := args[0].(*object.Array)
+	if !ok {
+		return newError("argument to 'from_bytes' not supported: got=%s", args[0].Type())
+	}
+	b := make([]byte, len(arg.Elements))
+	for i, e := range arg.Elements {
+		integer, ok := e.(*object.Integer)
+		if !ok || integer.Value < 0 || integer.Value > 255 {
+			return newError("argument to 'from_bytes' must be an array of bytes: got=%s at index %d", e.Inspect(), i)
+		}
+		b[i] = byte(integer.Value)
+	}
+	return &object.String{Value: string(b)}
+}
+
+// Higher-order builtins call the functions passed to them through call,
+// which runs them on the engine executing the builtin.
+
+func builtinMap(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunctionArgs("map", args)
+	if err != nil {
+		return err
+	}
+	elems := make([]object.Object, len(arr.Elements))
+	for i, e := range arr.Elements {
+		result := call(fn, e)
+		if isError(result) {
+			return result
+		}
+		elems[i] = result
+	}
+	return &object.Array{Elements: elems}
+}
+
+func builtinFilter(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunctionArgs("filter", args)
+	if err != nil {
+		return err
+	}
+	elems := []object.Object{}
+	for _, e := range arr.Elements {
+		result := call(fn, e)
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) {
+			elems = append(elems, e)
+		}
+	}
+	return &object.Array{Elements: elems}
+}
+
+// builtinReduce folds the array with fn(acc, elem), starting with the
+// initial value or, if it is omitted, with the first element.
+func builtinReduce(call object.CallFunction, args ...object.Object) object.Object {
+	if err := checkArgCount(args, 2, 3); err != nil {
+		return err
+	}
+	arr, fn, err := arrayAndFunctionArgs("reduce", args[:2])
+	if err != nil {
+		return err
+	}
+	elems := arr.Elements
+	var acc object.Object
+	if len(args) == 3 {
+		acc = args[2]
+	} else {
+		if len(elems) == 0 {
+			return newError("reduce of empty array with no initial value")
+		}
+		acc, elems = elems[0], elems[1:]
+	}
+	for _, e := range elems {
+		acc = call(fn, acc, e)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+func builtinEach(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunctionArgs("each", args)
+	if err != nil {
+		return err
+	}
+	for _, e := range arr.Elements {
+		if result := call(fn, e); isError(result) {
+			return result
+		}
+	}
+	return NULL
+}
+
+// builtinFind returns the first element for which fn is truthy, or null.
+func builtinFind(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunctionArgs("find", args)
+	if err != nil {
+		return err
+	}
+	for _, e := range arr.Elements {
+		result := call(fn, e)
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) {
+			return e
+		}
+	}
+	return NULL
+}
+
+func builtinAny(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunctionArgs("any", args)
+	if err != nil {
+		return err
+	}
+	for _, e := range arr.Elements {
+		result := call(fn, e)
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) {
+			return TRUE
+		}
+	}
+	return FALSE
+}
+
+func builtinAll(call object.CallFunction, args ...object.Object) object.Object {
+	arr, fn, err := arrayAndFunctionArgs("all", args)
+	if err != nil {
+		return err
+	}
+	for _, e := range arr.Elements {
+		result := call(fn, e)
+		if isError(result) {
+			return result
+		}
+		if !isTruthy(result) {
+			return FALSE
+		}
+	}
+	return TRUE
+}
+
+// builtinSort returns a sorted copy of the array. Without a comparator the
+// elements are ordered with <. A comparator fn(a, b) returns whether a goes
+// before b, or an integer that is negative if a goes before b, like a - b.
+// The sort is stable.
+func builtinSort(call object.CallFunction, args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 2); err != nil {
+		return err
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return argumentError("sort", 0, object.ArrayObj, args[0])
+	}
+	less := func(a, b object.Object) object.Object {
+		return evalInfixExpression("<", a, b)
+	}
+	if len(args) == 2 {
+		if !isCallable(args[1]) {
+			return argumentError("sort", 1, object.FunctionObj, args[1])
+		}
+		less = func(a, b object.Object) object.Object {
+			result := call(args[1], a, b)
+			switch result := result.(type) {
+			case *object.Boolean, *object.Error:
+				return result
+			case *object.Integer:
+				return nativeBoolToBooleanObject(result.Value < 0)
+			default:
+				return newError("sort comparator must return BOOLEAN or INTEGER, got=%s", result.Type())
+			}
+		}
+	}
+
+	elems := make([]object.Object, len(arr.Elements))
+	copy(elems, arr.Elements)
+	var err object.Object
+	sort.SliceStable(elems, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		result := less(elems[i], elems[j])
+		if isError(result) {
+			err = result
+			return false
+		}
+		return isTruthy(result)
+	})
+	if err != nil {
+		return err
+	}
+	return &object.Array{Elements: elems}
+}
+
+func builtinReverse(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	switch arg := args[0].(type) {
+	case *object.Array:
+		n := len(arg.Elements)
+		elems := make([]object.Object, n)
+		for i, e := range arg.Elements {
+			elems[n-1-i] = e
+		}
+		return &object.Array{Elements: elems}
+	case *object.String:
+		runes := []rune(arg.Value)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return &object.String{Value: string(runes)}
+	default:
+		return newError("argument to 'reverse' not supported: got=%s", arg.Type())
+	}
+}
+
+// builtinZip returns arrays of the elements at the same index of each
+// array, as long as the shortest array.
+func builtinZip(args ...object.Object) object.Object {
+	if len(args) < 1 {
+		return newError("wrong number of arguments: want at least 1, got=%d", len(args))
+	}
+	arrays := make([]*object.Array, len(args))
+	n := -1
+	for i, arg := range args {
+		arr, ok := arg.(*object.Array)
+		if !ok {
+			return argumentError("zip", i, object.ArrayObj, arg)
+		}
+		arrays[i] = arr
+		if n < 0 || len(arr.Elements) < n {
+			n = len(arr.Elements)
+		}
+	}
+	elems := make([]object.Object, n)
+	for i := range elems {
+		tuple := make([]object.Object, len(arrays))
+		for j, arr := range arrays {
+			tuple[j] = arr.Elements[i]
+		}
+		elems[i] = &object.Array{Elements: tuple}
+	}
+	return &object.Array{Elements: elems}
+}
+
+// builtinRange returns the integers from start, which defaults to 0, up to
+// but not including end, counting by step, which defaults to 1.
+func builtinRange(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 3); err != nil {
+		return err
+	}
+	bounds := make([]int64, len(args))
+	for i := range args {
+		v, err := integerArg("range", args, i)
+		if err != nil {
+			return err
+		}
+		bounds[i] = v
+	}
+	start, end, step := int64(0), bounds[0], int64(1)
+	if len(bounds) > 1 {
+		start, end = bounds[0], bounds[1]
+	}
+	if len(bounds) > 2 {
+		step = bounds[2]
+	}
+	if step == 0 {
+		return newError("range step must not be 0")
+	}
+
+	var n int64
+	if step > 0 && start < end {
+		n = (end-start-1)/step + 1
+	} else if step < 0 && start > end {
+		n = (start-end-1)/-step + 1
+	}
+	if n > maxRangeLen || n < 0 {
+		return newError("range too large: %d to %d by %d", start, end, step)
+	}
+	elems := make([]object.Object, n)
+	for i := range elems {
+		elems[i] = &object.Integer{Value: start + int64(i)*step}
+	}
+	return &object.Array{Elements: elems}
+}
+
+// builtinFlatten replaces nested arrays with their elements, up to depth
+// levels deep. depth defaults to 1.
+func builtinFlatten(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 2); err != nil {
+		return err
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return argumentError("flatten", 0, object.ArrayObj, args[0])
+	}
+	depth := int64(1)
+	if len(args) == 2 {
+		var err *object.Error
+		if depth, err = integerArg("flatten", args, 1); err != nil {
+			return err
+		}
+	}
+	elems, ok := flatten(nil, arr, depth, make(map[*object.Array]bool))
+	if !ok {
+		return newError("argument 1 to 'flatten' must not contain itself")
+	}
+	if elems == nil {
+		elems = []object.Object{}
+	}
+	return &object.Array{Elements: elems}
+}
+
+// flatten appends the elements of arr to dst, replacing arrays with their
+// elements up to depth levels deep. visiting holds the arrays being
+// flattened; it reports false if one of them contains itself.
+func flatten(dst []object.Object, arr *object.Array, depth int64, visiting map[*object.Array]bool) ([]object.Object, bool) {
+	if visiting[arr] {
+		return nil, false
+	}
+	visiting[arr] = true
+	defer delete(visiting, arr)
+
+	for _, e := range arr.Elements {
+		inner, ok := e.(*object.Array)
+		if !ok || depth == 0 {
+			dst = append(dst, e)
+			continue
+		}
+		if dst, ok = flatten(dst, inner, depth-1, visiting); !ok {
+			return nil, false
+		}
+	}
+	return dst, true
+}
+
+func builtinConcat(args ...object.Object) object.Object {
+	elems := []object.Object{}
+	for i, arg := range args {
+		arr, ok := arg.(*object.Array)
+		if !ok {
+			return argumentError("concat", i, object.ArrayObj, arg)
+		}
+		elems = append(elems, arr.Elements...)
+	}
+	return &object.Array{Elements: elems}
+}
+
+// builtinContains reports whether a string contains a substring, or an
+// array contains an element equal to the value.
+func builtinContains(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 2, 2); err != nil {
+		return err
+	}
+	switch arg := args[0].(type) {
+	case *object.String:
+		sub, err := stringArg("contains", args, 1)
+		if err != nil {
+			return err
+		}
+		return nativeBoolToBooleanObject(strings.Contains(arg.Value, sub))
+	case *object.Array:
+		return nativeBoolToBooleanObject(indexOf(arg.Elements, args[1]) >= 0)
+	default:
+		return newError("argument to 'contains' not supported: got=%s", arg.Type())
+	}
+}
+
+// builtinIndexOf returns the index of the first occurrence of a substring
+// in a string, in characters, or of a value in an array, or -1.
+func builtinIndexOf(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 2, 2); err != nil {
+		return err
+	}
+	switch arg := args[0].(type) {
+	case *object.String:
+		sub, err := stringArg("index_of", args, 1)
+		if err != nil {
+			return err
+		}
+		i := strings.Index(arg.Value, sub)
+		if i < 0 {
+			return &object.Integer{Value: -1}
+		}
+		return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value[:i]))}
+	case *object.Array:
+		return &object.Integer{Value: int64(indexOf(arg.Elements, args[1]))}
+	default:
+		return newError("argument to 'index_of' not supported: got=%s", arg.Type())
+	}
+}
+
+func indexOf(elems []object.Object, value object.Object) int {
+	for i, e := range elems {
+		if evalInfixExpression("==", e, value) == TRUE {
+			return i
+		}
+	}
+	return -1
+}
+
+func arrayAndFunctionArgs(name string, args []object.Object) (*object.Array, object.Object, *object.Error) {
+	if err := checkArgCount(args, 2, 2); err != nil {
+		return nil, nil, err
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, nil, argumentError(name, 0, object.ArrayObj, args[0])
+	}
+	if !isCallable(args[1]) {
+		return nil, nil, argumentError(name, 1, object.FunctionObj, args[1])
+	}
+	return arr, args[1], nil
+}
+
+func isCallable(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Function, *object.Closure, *object.Builtin:
+		return true
+	}
+	return false
+}
+
+// Hash builtins return pairs in insertion order and, like push, return new
+// hashes instead of modifying their arguments.
+
+func builtinKeys(args ...object.Object) object.Object {
+	hash, err := hashArg("keys", args, 1)
+	if err != nil {
+		return err
+	}
+	pairs := hash.Pairs()
+	elems := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		elems[i] = pair.Key
+	}
+	return &object.Array{Elements: elems}
+}
+
+func builtinValues(args ...object.Object) object.Object {
+	hash, err := hashArg("values", args, 1)
+	if err != nil {
+		return err
+	}
+	pairs := hash.Pairs()
+	elems := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		elems[i] = pair.Value
+	}
+	return &object.Array{Elements: elems}
+}
+
+// builtinEntries returns the pairs of a hash as [key, value] arrays.
+func builtinEntries(args ...object.Object) object.Object {
+	hash, err := hashArg("entries", args, 1)
+	if err != nil {
+		return err
+	}
+	pairs := hash.Pairs()
+	elems := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		elems[i] = &object.Array{Elements: []object.Object{pair.Key, pair.Value}}
+	}
+	return &object.Array{Elements: elems}
+}
+
+func builtinHasKey(args ...object.Object) object.Object {
+	hash, err := hashArg("has_key", args, 2)
+	if err != nil {
+		return err
+	}
+	key, ok := object.ToHashable(args[1])
+	if !ok {
+		return newError("unusable as hash key: %s", args[1].Type())
+	}
+	_, ok = hash.Get(key)
+	return nativeBoolToBooleanObject(ok)
+}
+
+// builtinDelete returns a copy of the hash without the key.
+func builtinDelete(args ...object.Object) object.Object {
+	hash, err := hashArg("delete", args, 2)
+	if err != nil {
+		return err
+	}
+	key, ok := object.ToHashable(args[1])
+	if !ok {
+		return newError("unusable as hash key: %s", args[1].Type())
+	}
+	result := hash.Copy()
+	result.Delete(key)
+	return result
+}
+
+// builtinMerge returns a hash with the pairs of all its arguments. Values
+// of later hashes replace those of earlier ones with the same key.
+func builtinMerge(args ...object.Object) object.Object {
+	result := &object.Hash{}
+	for i, arg := range args {
+		hash, ok := arg.(*object.Hash)
+		if !ok {
+			return argumentError("merge", i, object.HashObj, arg)
+		}
+		for _, pair := range hash.Pairs() {
+			result.Set(pair.Key.(object.Hashable), pair.Value)
+		}
+	}
+	return result
+}
+
+// builtinHash builds a hash from [key, value] arrays, like the ones
+// returned by entries and zip.
+func builtinHash(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return argumentError("hash", 0, object.ArrayObj, args[0])
+	}
+	result := &object.Hash{}
+	for i, e := range arr.Elements {
+		pair, ok := e.(*object.Array)
+		if !ok || len(pair.Elements) != 2 {
+			return newError("argument 1 to 'hash' must be an array of [key, value] pairs: got=%s at index %d", e.Inspect(), i)
+		}
+		key, ok := object.ToHashable(pair.Elements[0])
+		if !ok {
+			return newError("unusable as hash key: %s", pair.Elements[0].Type())
+		}
+		result.Set(key, pair.Elements[1])
+	}
+	return result
+}
+
+func hashArg(name string, args []object.Object, count int) (*object.Hash, *object.Error) {
+	if err := checkArgCount(args, count, count); err != nil {
+		return nil, err
+	}
+	hash, ok := args[0].(*object.Hash)
+	if !ok {
+		return nil, argumentError(name, 0, object.HashObj, args[0])
+	}
+	return hash, nil
+}
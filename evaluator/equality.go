@@ -0,0 +1,118 @@
+package evaluator
+
+import (
+	"github.com/lusingander/monkey/object"
+)
+
+// equal reports whether a and b are structurally equal. Numbers compare by
+// value across Integer, BigInt and Float, arrays element by element, hashes
+// by their pairs regardless of order and quotes by their source. Functions
+// and other objects are equal only to themselves.
+func equal(a, b object.Object) bool {
+	return deepEqual(a, b, nil)
+}
+
+// deepEqual keeps the pairs of arrays and hashes being compared in seen,
+// so that values which contain themselves do not recurse forever.
+func deepEqual(a, b object.Object, seen map[[2]object.Object]bool) bool {
+	if a == b {
+		return true
+	}
+	if isNumber(a) && isNumber(b) {
+		return evalInfixExpression("==", a, b) == TRUE
+	}
+	switch a := a.(type) {
+	case *object.String:
+		b, ok := b.(*object.String)
+		return ok && a.Value == b.Value
+	case *object.Boolean:
+		b, ok := b.(*object.Boolean)
+		return ok && a.Value == b.Value
+	case *object.Null:
+		_, ok := b.(*object.Null)
+		return ok
+	case *object.Quote:
+		b, ok := b.(*object.Quote)
+		return ok && a.Node.String() == b.Node.String()
+	case *object.Array:
+		b, ok := b.(*object.Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		if seen, ok = visit(seen, a, b); !ok {
+			return true
+		}
+		for i := range a.Elements {
+			if !deepEqual(a.Elements[i], b.Elements[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		b, ok := b.(*object.Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		if seen, ok = visit(seen, a, b); !ok {
+			return true
+		}
+		for _, pair := range a.Pairs() {
+			other, ok := b.Get(pair.Key.(object.Hashable))
+			if !ok || !deepEqual(pair.Value, other.Value, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// visit records the pair a, b in seen. It reports false if the pair is
+// already being compared.
+func visit(seen map[[2]object.Object]bool, a, b object.Object) (map[[2]object.Object]bool, bool) {
+	if seen == nil {
+		seen = make(map[[2]object.Object]bool)
+	}
+	pair := [2]object.Object{a, b}
+	if seen[pair] {
+		return seen, false
+	}
+	seen[pair] = true
+	return seen, true
+}
+
+// evalArrayCompareExpression orders arrays lexicographically: by the first
+// elements that differ, or by length if one is a prefix of the other.
+func evalArrayCompareExpression(operator string, left, right object.Object) object.Object {
+	return compareArrays(operator, left.(*object.Array), right.(*object.Array), nil)
+}
+
+// compareArrays keeps the pairs of arrays being compared in seen, like
+// deepEqual. Arrays that differ only inside themselves cannot be ordered.
+func compareArrays(operator string, left, right *object.Array, seen map[[2]object.Object]bool) object.Object {
+	seen, ok := visit(seen, left, right)
+	if !ok {
+		return newError("cannot order arrays that contain themselves")
+	}
+	lv, rv := left.Elements, right.Elements
+	for i := 0; i < len(lv) && i < len(rv); i++ {
+		if equal(lv[i], rv[i]) {
+			continue
+		}
+		l, lok := lv[i].(*object.Array)
+		r, rok := rv[i].(*object.Array)
+		if lok && rok {
+			return compareArrays(operator, l, r, seen)
+		}
+		return evalInfixExpression(operator, lv[i], rv[i])
+	}
+	return evalIntegerInfixExpression(operator, &object.Integer{Value: int64(len(lv))}, &object.Integer{Value: int64(len(rv))})
+}
+
+func isComparison(operator string) bool {
+	switch operator {
+	case "<", ">", "<=", ">=":
+		return true
+	}
+	return false
+}
@@ -2,27 +2,62 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"strings"
 
 	"github.com/lusingander/monkey/ast"
 	"github.com/lusingander/monkey/object"
+	"github.com/lusingander/monkey/token"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// CheckOverflow makes integer arithmetic that overflows int64 an error
+// instead of promoting the result to a BigInt.
+var CheckOverflow = false
+
+// StrictIndex makes an out-of-range array or string index an error instead
+// of NULL, and out-of-range slice bounds an error instead of being clamped.
+var StrictIndex = false
+
+// MaxCallDepth is the number of nested function calls after which
+// evaluation stops with a stack overflow error. Tail calls do not nest.
+var MaxCallDepth = 100000
+
+// callStack holds the functions currently being applied.
+// The evaluator is not safe for concurrent use.
+var callStack []object.Frame
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := evalNode(node, env)
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() && node != nil {
+		// the innermost node that produced the error determines its position
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+func evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node.Statements, env)
 	case *ast.BlockStatement:
 		return evalBlockStatements(node.Statements, env)
 	case *ast.ExpressionStatement:
+		if exp, ok := node.Expression.(*ast.IfExpression); ok {
+			// break and continue pass through an if statement to the loop
+			return evalIfExpression(exp, env, false)
+		}
 		return Eval(node.Expression, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := evalTail(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
@@ -32,9 +67,32 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
+		if fn, ok := val.(*object.Function); ok && fn.Name == "" {
+			fn.Name = node.Name.Value
+		}
 		env.Set(node.Name.Value, val)
+	case *ast.ExportStatement:
+		if env.Outer() != nil {
+			return newError("export is only allowed at the top level")
+		}
+		if val := Eval(node.Statement, env); isError(val) {
+			return val
+		}
+		env.Export(node.Statement.Name.Value)
+	case *ast.ImportExpression:
+		return evalImportExpression(node)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return ifValue(evalIfExpression(node, env, false))
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
@@ -42,6 +100,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -51,23 +112,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
 		return &object.Function{Parameters: params, Body: body, Env: env}
 	case *ast.CallExpression:
-		if node.Function.TokenLiteral() == "quote" {
-			return quote(node.Arguments[0], env)
-		}
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
-		}
-		return applyFunction(function, args)
+		return evalCallExpression(node, env, false)
 	case *ast.ArrayLiteral:
 		elems := evalExpressions(node.Elements, env)
 		if len(elems) == 1 && isError(elems[0]) {
@@ -84,11 +136,16 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		return evalSlice(node, env)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
 	case *ast.FloatLiteral:
 		return &object.Float{Value: node.Value}
@@ -96,6 +153,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		parts := evalExpressions(node.Parts, env)
+		if len(parts) == 1 && isError(parts[0]) {
+			return parts[0]
+		}
+		return interpolate(parts)
 	}
 	return nil
 }
@@ -107,6 +170,9 @@ func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
+			if call, ok := result.Value.(*tailCall); ok {
+				return callFunction(call.fn, call.args, call.callSite)
+			}
 			return result.Value
 		case *object.Error:
 			return result
@@ -120,16 +186,62 @@ func evalBlockStatements(stmts []ast.Statement, env *object.Environment) object.
 	for _, stmt := range stmts {
 		result = Eval(stmt, env)
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.ReturnValueObj || rt == object.ErrorObj {
-				return result
-			}
+		if isBlockExit(result) {
+			return result
 		}
 	}
 	return result
 }
 
+// isBlockExit reports whether obj ends the evaluation of the enclosing
+// statements.
+func isBlockExit(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.ReturnValueObj, object.ErrorObj, object.BreakObj, object.ContinueObj:
+		return true
+	default:
+		return false
+	}
+}
+
+// evalTailBlock evaluates the body of a function. Its last statement is
+// in tail position.
+func evalTailBlock(block *ast.BlockStatement, env *object.Environment) object.Object {
+	stmts := block.Statements
+	if len(stmts) == 0 {
+		return nil
+	}
+	result := evalBlockStatements(stmts[:len(stmts)-1], env)
+	if isBlockExit(result) {
+		return result
+	}
+	if stmt, ok := stmts[len(stmts)-1].(*ast.ExpressionStatement); ok {
+		return evalTail(stmt.Expression, env)
+	}
+	return Eval(stmts[len(stmts)-1], env)
+}
+
+// evalTail evaluates an expression in tail position. A call to a function
+// is not applied but returned as a tailCall for applyFunction to run.
+func evalTail(node ast.Expression, env *object.Environment) object.Object {
+	var result object.Object
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		result = evalCallExpression(node, env, true)
+	case *ast.IfExpression:
+		result = ifValue(evalIfExpression(node, env, true))
+	default:
+		return Eval(node, env)
+	}
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 	for _, exp := range exps {
@@ -142,12 +254,24 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
+// interpolate concatenates the parts of an interpolated string, converting
+// values with Inspect.
+func interpolate(parts []object.Object) *object.String {
+	var out strings.Builder
+	for _, p := range parts {
+		out.WriteString(p.Inspect())
+	}
+	return &object.String{Value: out.String()}
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalTildePrefixOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -170,7 +294,16 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	switch right.Type() {
 	case object.IntegerObj:
 		value := right.(*object.Integer).Value
+		if value == math.MinInt64 {
+			if CheckOverflow {
+				return newError("integer overflow: -(%d)", value)
+			}
+			return newInteger(new(big.Int).Neg(big.NewInt(value)))
+		}
 		return &object.Integer{Value: -value}
+	case object.BigIntObj:
+		value := right.(*object.BigInt).Value
+		return newInteger(new(big.Int).Neg(value))
 	case object.FloatObj:
 		value := right.(*object.Float).Value
 		return &object.Float{Value: -value}
@@ -179,22 +312,57 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	}
 }
 
+func evalTildePrefixOperatorExpression(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^right.Value}
+	case *object.BigInt:
+		return newInteger(new(big.Int).Not(right.Value))
+	default:
+		return newError("unknown operator: ~%s", right.Type())
+	}
+}
+
+// evalLogicalExpression evaluates && and ||. The right operand is only
+// evaluated if the left one does not determine the result.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.IntegerObj && right.Type() == object.IntegerObj:
 		return evalIntegerInfixExpression(operator, left, right)
-	case left.Type() == object.FloatObj && right.Type() == object.FloatObj:
-		return evalFloatInfixExpression(operator, left, right)
-	case left.Type() == object.IntegerObj && right.Type() == object.FloatObj:
-		return evalFloatInfixExpression(operator, left, right)
-	case left.Type() == object.FloatObj && right.Type() == object.IntegerObj:
+	case isInteger(left) && isInteger(right):
+		return evalBigIntInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
 		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.StringObj && right.Type() == object.StringObj:
 		return evalStringInfixExpression(operator, left, right)
+	case operator == "*" && left.Type() == object.StringObj && right.Type() == object.IntegerObj:
+		return repeatString(left.(*object.String).Value, right.(*object.Integer).Value)
+	case operator == "*" && left.Type() == object.IntegerObj && right.Type() == object.StringObj:
+		return repeatString(right.(*object.String).Value, left.(*object.Integer).Value)
+	case left.Type() == object.ArrayObj && right.Type() == object.ArrayObj && isComparison(operator):
+		return evalArrayCompareExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -205,6 +373,15 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
 	lv := left.(*object.Integer).Value
 	rv := right.(*object.Integer).Value
+	if (operator == "/" || operator == "%") && rv == 0 {
+		return newError("division by zero: %d %s %d", lv, operator, rv)
+	}
+	if overflows(operator, lv, rv) {
+		if CheckOverflow {
+			return newError("integer overflow: %d %s %d", lv, operator, rv)
+		}
+		return evalBigIntInfixExpression(operator, left, right)
+	}
 	switch operator {
 	case "+":
 		return &object.Integer{Value: lv + rv}
@@ -214,6 +391,27 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 		return &object.Integer{Value: lv * rv}
 	case "/":
 		return &object.Integer{Value: lv / rv}
+	case "%":
+		return &object.Integer{Value: lv % rv}
+	case "**":
+		if rv < 0 {
+			return &object.Float{Value: math.Pow(float64(lv), float64(rv))}
+		}
+		return &object.Integer{Value: intPow(lv, rv)}
+	case "&":
+		return &object.Integer{Value: lv & rv}
+	case "|":
+		return &object.Integer{Value: lv | rv}
+	case "^":
+		return &object.Integer{Value: lv ^ rv}
+	case "<<", ">>":
+		if rv < 0 {
+			return newError("negative shift count: %d", rv)
+		}
+		if operator == "<<" {
+			return &object.Integer{Value: lv << uint64(rv)}
+		}
+		return &object.Integer{Value: lv >> uint64(rv)}
 	case "<":
 		return nativeBoolToBooleanObject(lv < rv)
 	case ">":
@@ -231,20 +429,73 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
-func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
-	var lv, rv float64
-	l, ok := left.(*object.Float)
-	if ok {
-		lv = l.Value
-	} else {
-		lv = float64(left.(*object.Integer).Value)
+// intPow computes base ** exp for exp >= 0 by repeated squaring.
+func intPow(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
 	}
-	r, ok := right.(*object.Float)
-	if ok {
-		rv = r.Value
-	} else {
-		rv = float64(right.(*object.Integer).Value)
+	return result
+}
+
+// overflows reports whether lv operator rv overflows int64.
+func overflows(operator string, lv, rv int64) bool {
+	switch operator {
+	case "+":
+		return (rv > 0 && lv > math.MaxInt64-rv) || (rv < 0 && lv < math.MinInt64-rv)
+	case "-":
+		return (rv < 0 && lv > math.MaxInt64+rv) || (rv > 0 && lv < math.MinInt64+rv)
+	case "*":
+		return mulOverflows(lv, rv)
+	case "/":
+		return lv == math.MinInt64 && rv == -1
+	case "**":
+		if rv < 0 {
+			return false
+		}
+		result := int64(1)
+		for i := int64(0); i < rv; i++ {
+			if mulOverflows(result, lv) {
+				return true
+			}
+			result *= lv
+			if result == 0 || result == 1 {
+				return false
+			}
+		}
+		return false
+	case "<<":
+		if rv < 0 || lv == 0 {
+			return false
+		}
+		return rv >= 64 || lv<<uint64(rv)>>uint64(rv) != lv
+	default:
+		return false
 	}
+}
+
+func mulOverflows(a, b int64) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	c := a * b
+	return c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64)
+}
+
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	if isInteger(left) || isInteger(right) {
+		if c, ok := compareNumbers(left, right); ok {
+			if result, ok := evalComparison(operator, c); ok {
+				return result
+			}
+		}
+	}
+	lv := toFloat(left)
+	rv := toFloat(right)
 	switch operator {
 	case "+":
 		return &object.Float{Value: lv + rv}
@@ -254,6 +505,10 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 		return &object.Float{Value: lv * rv}
 	case "/":
 		return &object.Float{Value: lv / rv}
+	case "%":
+		return &object.Float{Value: math.Mod(lv, rv)}
+	case "**":
+		return &object.Float{Value: math.Pow(lv, rv)}
 	case "<":
 		return nativeBoolToBooleanObject(lv < rv)
 	case ">":
@@ -277,6 +532,14 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	switch operator {
 	case "+":
 		return &object.String{Value: lv + rv}
+	case "<":
+		return nativeBoolToBooleanObject(lv < rv)
+	case ">":
+		return nativeBoolToBooleanObject(lv > rv)
+	case "<=":
+		return nativeBoolToBooleanObject(lv <= rv)
+	case ">=":
+		return nativeBoolToBooleanObject(lv >= rv)
 	case "==":
 		return nativeBoolToBooleanObject(lv == rv)
 	case "!=":
@@ -286,19 +549,198 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	}
 }
 
-func evalIfExpression(exp *ast.IfExpression, env *object.Environment) object.Object {
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIdentifier(target, env)
+			if isError(current) {
+				return current
+			}
+		}
+		val := evalAssignedValue(node, current, env)
+		if isError(val) {
+			return val
+		}
+		if !env.Assign(target.Value, val) {
+			return newError("cannot assign to undefined identifier: %s", target.Value)
+		}
+		return val
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+		val := evalAssignedValue(node, current, env)
+		if isError(val) {
+			return val
+		}
+		return evalIndexAssignment(left, index, val)
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+// evalAssignedValue evaluates the right-hand side of an assignment and, for
+// a compound assignment, combines it with the current value of the target.
+func evalAssignedValue(node *ast.AssignExpression, current object.Object, env *object.Environment) object.Object {
+	val := Eval(node.Value, env)
+	if isError(val) || node.Operator == "=" {
+		return val
+	}
+	operator := strings.TrimSuffix(node.Operator, "=")
+	return evalInfixExpression(operator, current, val)
+}
+
+func evalIndexAssignment(left, index, val object.Object) object.Object {
+	switch {
+	case left.Type() == object.ArrayObj && index.Type() == object.IntegerObj:
+		elements := left.(*object.Array).Elements
+		idx := index.(*object.Integer).Value
+		i, ok := normalizeIndex(idx, int64(len(elements)))
+		if !ok {
+			return newError("index out of range: %d", idx)
+		}
+		elements[i] = val
+		return val
+	case left.Type() == object.HashObj:
+		key, ok := object.ToHashable(index)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Set(key, val)
+		return val
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
+func evalIfExpression(exp *ast.IfExpression, env *object.Environment, tail bool) object.Object {
 	condition := Eval(exp.Condition, env)
 	if isError(condition) {
 		return condition
 	}
 
+	var branch *ast.BlockStatement
 	if isTruthy(condition) {
-		return Eval(exp.Consequence, env)
+		branch = exp.Consequence
 	} else if exp.Alternative != nil {
-		return Eval(exp.Alternative, env)
+		branch = exp.Alternative
 	} else {
 		return NULL
 	}
+	if tail {
+		return evalTailBlock(branch, env)
+	}
+	return Eval(branch, env)
+}
+
+// ifValue returns the value of an if expression used as a value, which
+// break and continue cannot leave.
+func ifValue(obj object.Object) object.Object {
+	switch obj.(type) {
+	case *object.Break:
+		return newError("break inside an expression")
+	case *object.Continue:
+		return newError("continue inside an expression")
+	}
+	return obj
+}
+
+// Like the other loops, a while loop runs in an environment of its own, so
+// that its bindings are not visible after it.
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	env = object.NewEnclosedEnvironment(env)
+	for {
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return nil
+		}
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+	}
+}
+
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	env = object.NewEnclosedEnvironment(env)
+	if node.Init != nil {
+		if init := Eval(node.Init, env); isError(init) {
+			return init
+		}
+	}
+	for {
+		if node.Condition != nil {
+			condition := Eval(node.Condition, env)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				return nil
+			}
+		}
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+		if node.Post != nil {
+			if post := Eval(node.Post, env); isError(post) {
+				return post
+			}
+		}
+	}
+}
+
+func evalForInStatement(node *ast.ForInStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	array, ok := iterable.(*object.Array)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+	// iterate over a copy so that the body cannot affect the iteration
+	elements := append([]object.Object(nil), array.Elements...)
+	env = object.NewEnclosedEnvironment(env)
+	for _, elem := range elements {
+		env.Set(node.Variable.Value, elem)
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+	}
+	return nil
+}
+
+// evalLoopBody evaluates one iteration of a loop. It reports whether the
+// loop ends, along with the result to propagate out of the loop.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := Eval(body, env)
+	if result == nil {
+		return nil, false
+	}
+	switch result.Type() {
+	case object.ReturnValueObj, object.ErrorObj:
+		return result, true
+	case object.BreakObj:
+		return nil, true
+	default:
+		return nil, false
+	}
 }
 
 func isTruthy(obj object.Object) bool {
@@ -331,12 +773,81 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	return newError("identifier not found: %s", node.Value)
 }
 
+// tailCall is a call in tail position. It is handed back to applyFunction,
+// which runs it in a loop instead of recursing, so that deep tail
+// recursion runs in constant Go stack.
+type tailCall struct {
+	fn       *object.Function
+	args     []object.Object
+	callSite token.Position
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+func evalCallExpression(node *ast.CallExpression, env *object.Environment, tail bool) object.Object {
+	if node.Function.TokenLiteral() == "quote" {
+		return quote(node.Arguments[0], env)
+	}
+	function := Eval(node.Function, env)
+	if isError(function) {
+		return function
+	}
+	args := evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+	if fn, ok := function.(*object.Function); ok && tail {
+		return &tailCall{fn: fn, args: args, callSite: node.Pos()}
+	}
+	return callFunction(function, args, node.Pos())
+}
+
+func callFunction(function object.Object, args []object.Object, callSite token.Position) object.Object {
+	switch fn := function.(type) {
+	case *object.Function:
+		if err := pushFrame(fn, args, callSite); err != nil {
+			return err
+		}
+		defer popFrame()
+	case *object.Builtin:
+		if fn.CallbackFn != nil {
+			// callbacks are reported as called from the call of the builtin
+			return fn.CallbackFn(func(f object.Object, args ...object.Object) object.Object {
+				return callFunction(f, args, callSite)
+			}, args...)
+		}
+	}
+	return applyFunction(function, args)
+}
+
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		// the frame of the first tail call is reused by all following ones
+		pushed := false
+		defer func() {
+			if pushed {
+				popFrame()
+			}
+		}()
+		for {
+			extendedEnv := extendFunctionEnv(fn, args)
+			evaluated := unwrapReturnValue(evalTailBlock(fn.Body, extendedEnv))
+			call, ok := evaluated.(*tailCall)
+			if !ok {
+				return evaluated
+			}
+			if pushed {
+				popFrame()
+			}
+			if err := pushFrame(call.fn, call.args, call.callSite); err != nil {
+				pushed = false
+				return err
+			}
+			pushed = true
+			fn, args = call.fn, call.args
+		}
 	case *object.Builtin:
 		return fn.Fn(args...)
 	default:
@@ -344,6 +855,31 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
+// pushFrame checks that fn can be called with args and records the call.
+func pushFrame(fn *object.Function, args []object.Object, callSite token.Position) *object.Error {
+	var err *object.Error
+	switch {
+	case len(args) != len(fn.Parameters):
+		err = newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+	case len(callStack) >= MaxCallDepth:
+		err = newError("stack overflow")
+	}
+	if err != nil {
+		err.Pos = callSite
+		return err
+	}
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	callStack = append(callStack, object.Frame{Function: name, CallSite: callSite})
+	return nil
+}
+
+func popFrame() {
+	callStack = callStack[:len(callStack)-1]
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
 	env := object.NewEnclosedEnvironment(fn.Env)
 	for paramIdx, param := range fn.Parameters {
@@ -363,63 +899,219 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ArrayObj && index.Type() == object.IntegerObj:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.StringObj && index.Type() == object.IntegerObj:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HashObj:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.ModuleObj:
+		return evalModuleIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
 }
 
+// A negative index counts from the end. An index that is out of range
+// yields NULL, or an error if StrictIndex is set.
+
 func evalArrayIndexExpression(array, index object.Object) object.Object {
-	arrayObject := array.(*object.Array)
+	elements := array.(*object.Array).Elements
 	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
-	if idx < 0 || max < idx {
-		return NULL
+	i, ok := normalizeIndex(idx, int64(len(elements)))
+	if !ok {
+		return indexOutOfRange(idx)
+	}
+	return elements[i]
+}
+
+// evalStringIndexExpression returns the character at index as a string.
+// Strings are indexed by character, not by byte.
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	runes := []rune(str.(*object.String).Value)
+	idx := index.(*object.Integer).Value
+	i, ok := normalizeIndex(idx, int64(len(runes)))
+	if !ok {
+		return indexOutOfRange(idx)
+	}
+	return &object.String{Value: string(runes[i])}
+}
+
+// normalizeIndex resolves a negative index against length and reports
+// whether the result is in range.
+func normalizeIndex(idx, length int64) (int64, bool) {
+	if idx < 0 {
+		idx += length
+	}
+	return idx, 0 <= idx && idx < length
+}
+
+func indexOutOfRange(idx int64) object.Object {
+	if StrictIndex {
+		return newError("index out of range: %d", idx)
+	}
+	return NULL
+}
+
+func evalSlice(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	bounds := [2]object.Object{NULL, NULL}
+	for i, bound := range []ast.Expression{node.Low, node.High} {
+		if bound == nil {
+			continue
+		}
+		bounds[i] = Eval(bound, env)
+		if isError(bounds[i]) {
+			return bounds[i]
+		}
+	}
+	return evalSliceExpression(left, bounds[0], bounds[1])
+}
+
+// evalSliceExpression returns a copy of the elements or characters of left
+// from low up to but not including high. NULL bounds stand for the start
+// and the end, and negative bounds count from the end. Bounds outside the
+// value are clamped like in Python, or an error if StrictIndex is set.
+func evalSliceExpression(left, low, high object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		lo, hi, err := sliceBounds(low, high, int64(len(left.Elements)))
+		if err != nil {
+			return err
+		}
+		elements := make([]object.Object, hi-lo)
+		copy(elements, left.Elements[lo:hi])
+		return &object.Array{Elements: elements}
+	case *object.String:
+		runes := []rune(left.Value)
+		lo, hi, err := sliceBounds(low, high, int64(len(runes)))
+		if err != nil {
+			return err
+		}
+		return &object.String{Value: string(runes[lo:hi])}
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+}
+
+func sliceBounds(low, high object.Object, length int64) (int64, int64, *object.Error) {
+	lo, err := sliceBound(low, 0, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := sliceBound(high, length, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	if StrictIndex && (lo < 0 || hi > length || lo > hi) {
+		return 0, 0, newError("slice bounds out of range: [%s:%s] with length %d", boundString(low), boundString(high), length)
+	}
+	lo = clamp(lo, 0, length)
+	hi = clamp(hi, lo, length)
+	return lo, hi, nil
+}
+
+func boundString(bound object.Object) string {
+	if bound == NULL {
+		return ""
+	}
+	return bound.Inspect()
+}
+
+func sliceBound(bound object.Object, omitted, length int64) (int64, *object.Error) {
+	switch bound := bound.(type) {
+	case *object.Null:
+		return omitted, nil
+	case *object.Integer:
+		if bound.Value < 0 {
+			return bound.Value + length, nil
+		}
+		return bound.Value, nil
+	default:
+		return 0, newError("slice index must be INTEGER, got=%s", bound.Type())
 	}
-	return arrayObject.Elements[idx]
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
+	hash := &object.Hash{}
+	for _, keyNode := range node.Keys {
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.ToHashable(key)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
-		value := Eval(valueNode, env)
+		value := Eval(node.Pairs[keyNode], env)
 		if isError(value) {
 			return value
 		}
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{
-			Key:   key,
-			Value: value,
-		}
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
+	key, ok := object.ToHashable(index)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
 	return pair.Value
 }
 
+// EvalPrefixExpression, EvalInfixExpression, EvalIndexExpression,
+// EvalIndexAssignment, EvalSliceExpression and Interpolate apply an operator
+// to already evaluated operands. The vm uses them so that both engines share
+// the same semantics.
+
+func EvalPrefixExpression(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func EvalInfixExpression(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+func EvalIndexExpression(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+func EvalIndexAssignment(left, index, val object.Object) object.Object {
+	return evalIndexAssignment(left, index, val)
+}
+
+func EvalSliceExpression(left, low, high object.Object) object.Object {
+	return evalSliceExpression(left, low, high)
+}
+
+func Interpolate(parts []object.Object) object.Object {
+	return interpolate(parts)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// PanicError turns a value recovered from a Go panic during evaluation into
+// an error and resets the state the panic left behind.
+func PanicError(r interface{}) *object.Error {
+	err := newError("internal error: %v", r)
+	callStack = nil
+	importing = nil
+	return err
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{
 		Message: fmt.Sprintf(format, a...),
+		Trace:   append([]object.Frame(nil), callStack...),
 	}
 }
 
@@ -1,214 +1,632 @@
-package evaluator
+package evaluator_test
 
 import (
 	"math"
 	"testing"
 
+	"github.com/lusingander/monkey/ast"
+	"github.com/lusingander/monkey/compiler"
+	"github.com/lusingander/monkey/evaluator"
 	"github.com/lusingander/monkey/lexer"
 	"github.com/lusingander/monkey/object"
 	"github.com/lusingander/monkey/parser"
+	"github.com/lusingander/monkey/vm"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"5", 5},
-		{"10", 10},
-		{"-5", -5},
-		{"-10", -10},
-		{"5 + 5 + 5 + 5 - 10", 10},
-		{"2 * 2 * 2 * 2 * 2", 32},
-		{"-50 + 100 - 50", 0},
-		{"5 * 2 + 10", 20},
-		{"5 + 2 * 10", 25},
-		{"20 + 2 * -10", 0},
-		{"50 / 2 * 2 + 10", 60},
-		{"2 * (5 + 10)", 30},
-		{"3 * 3 * 3 + 10", 37},
-		{"3 * (3 * 3) + 10", 37},
-		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
-		{"5 / 2", 2},
-		{"4 / 2", 2},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"5", 5},
+			{"10", 10},
+			{"-5", -5},
+			{"-10", -10},
+			{"0x1F", 31},
+			{"0b101", 5},
+			{"0o17", 15},
+			{"1_000_000", 1000000},
+			{"5 + 5 + 5 + 5 - 10", 10},
+			{"2 * 2 * 2 * 2 * 2", 32},
+			{"-50 + 100 - 50", 0},
+			{"5 * 2 + 10", 20},
+			{"5 + 2 * 10", 25},
+			{"20 + 2 * -10", 0},
+			{"50 / 2 * 2 + 10", 60},
+			{"2 * (5 + 10)", 30},
+			{"3 * 3 * 3 + 10", 37},
+			{"3 * (3 * 3) + 10", 37},
+			{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+			{"5 / 2", 2},
+			{"4 / 2", 2},
+			{"7 % 3", 1},
+			{"-7 % 3", -1},
+			{"2 + 7 % 3 * 2", 4},
+			{"2 ** 10", 1024},
+			{"2 ** 3 ** 2", 512},
+			{"-2 ** 2", -4},
+			{"3 ** 0", 1},
+			{"12 & 10", 8},
+			{"12 | 10", 14},
+			{"12 ^ 10", 6},
+			{"~5", -6},
+			{"1 << 10", 1024},
+			{"-16 >> 2", -4},
+			{"1 | 2 ^ 3 & 4 << 1", 3},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
-	}
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testIntegerObject(t, evaluated, tt.expected)
+		}
+	})
+}
+
+func TestEvalBigIntegerExpression(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"123456789012345678901234567890", "123456789012345678901234567890"},
+			{"9223372036854775807 + 1", "9223372036854775808"},
+			{"-9223372036854775807 - 2", "-9223372036854775809"},
+			{"4611686018427387904 * 4", "18446744073709551616"},
+			{"2 ** 64", "18446744073709551616"},
+			{"1 << 64", "18446744073709551616"},
+			{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+			{"~(2 ** 64)", "-18446744073709551617"},
+			{"99999999999999999999 + 1", "100000000000000000000"},
+			{"(2 ** 64) * (2 ** 64)", "340282366920938463463374607431768211456"},
+			{"let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(25)", "15511210043330985984000000"},
+			{"-9223372036854775808", -9223372036854775808},
+			{"99999999999999999999 - 99999999999999999998", 1},
+			{"(2 ** 64) / (2 ** 32)", 4294967296},
+			{"(2 ** 64) % 7", 2},
+			{"-(2 ** 64) / 3 * 3 + -(2 ** 64) % 3 + 2 ** 64", 0},
+			{"(2 ** 64) >> 60", 16},
+			{"(2 ** 64) >> 100", 0},
+			{"-(2 ** 64) >> 100", -1},
+			{"(2 ** 64) & 255", 0},
+			{"2 ** 64 > 2 ** 63", true},
+			{"2 ** 64 == 18446744073709551616", true},
+			{"2 ** 64 == 2 ** 63", false},
+			{"2 ** 63 > 1", true},
+			{"-(2 ** 63) < -1", true},
+			{"2 ** 64 * 0.5", 9223372036854775808.0},
+			{"(2 ** 64) ** -1", 1.0 / 18446744073709551616.0},
+			{"{2 ** 64: 5}[18446744073709551616]", 5},
+			{"9007199254740993 == 9007199254740992.0", false},
+			{"9007199254740993 != 9007199254740992.0", true},
+			{"9007199254740993 > 9007199254740992.0", true},
+			{"9007199254740992.0 < 9007199254740993", true},
+			{"9007199254740992 == 9007199254740992.0", true},
+			{"2 ** 64 + 1 == 18446744073709551616.0", false},
+			{"2 ** 64 <= 18446744073709551616.0", true},
+			{"1 == 1.5", false},
+			{"1 < 1.5", true},
+			{"(2 ** 64) < 1.0 / 0.0", true},
+			{"1 == 0.0 / 0.0", false},
+			{"1 != 0.0 / 0.0", true},
+			{"[9007199254740993] == [9007199254740992.0]", false},
+			{"len({9007199254740993: 1, 9007199254740992.0: 2})", 2},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case float64:
+				testFloatObject(t, evaluated, expected)
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case string:
+				testBigIntObject(t, evaluated, expected)
+			}
+		}
+	})
 }
 
 func TestEvalFloatExpression(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected float64
-	}{
-		{"1.23", 1.23},
-		{"123.45", 123.45},
-		{"0.0012", 0.0012},
-		{"-2.3", -2.3},
-		{"-23.45", -23.45},
-		{"0.1 + 0.1 + 0.1 + 0.1 - 0.2", 0.2},
-		{"0.2 * 0.2 * 0.2 * 0.2 * 0.2", 0.00032},
-		{"1 + 0.5 * 0.1", 1.05},
-		{"10.567 - 4 / 2", 8.567},
-		{"-9.999 - 0.001 + 5", -5},
-		{"5.0 / 2.0", 2.5},
-		{"4.0 / 2.0", 2},
-		{"5.0 / 2", 2.5},
-		{"4.0 / 2", 2},
-		{"5 / 2.0", 2.5},
-		{"4 / 2.0", 2},
-		{"2.0 * (5.4 + 9.6)", 30},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected float64
+		}{
+			{"1.23", 1.23},
+			{"123.45", 123.45},
+			{"0.0012", 0.0012},
+			{"-2.3", -2.3},
+			{"-23.45", -23.45},
+			{"0.1 + 0.1 + 0.1 + 0.1 - 0.2", 0.2},
+			{"0.2 * 0.2 * 0.2 * 0.2 * 0.2", 0.00032},
+			{"1 + 0.5 * 0.1", 1.05},
+			{"10.567 - 4 / 2", 8.567},
+			{"-9.999 - 0.001 + 5", -5},
+			{"5.0 / 2.0", 2.5},
+			{"4.0 / 2.0", 2},
+			{"5.0 / 2", 2.5},
+			{"4.0 / 2", 2},
+			{"5 / 2.0", 2.5},
+			{"4 / 2.0", 2},
+			{"2.0 * (5.4 + 9.6)", 30},
+			{"7.5 % 2", 1.5},
+			{"2.0 ** 0.5", 1.4142135623},
+			{"2 ** -1", 0.5},
+			{"1e3", 1000},
+			{".5 + 1", 1.5},
+			{"2.5e-3 * 2", 0.005},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testFloatObject(t, evaluated, tt.expected)
-	}
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testFloatObject(t, evaluated, tt.expected)
+		}
+	})
 }
 
 func TestEvalBooleanExpression(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected bool
-	}{
-		{"true", true},
-		{"false", false},
-		{"1 < 2", true},
-		{"1 > 2", false},
-		{"1 < 1", false},
-		{"1 > 1", false},
-		{"1 <= 2", true},
-		{"1 <= 1", true},
-		{"2 <= 1", false},
-		{"2 >= 1", true},
-		{"1 >= 1", true},
-		{"1 >= 2", false},
-		{"1 == 1", true},
-		{"1 != 1", false},
-		{"1 == 2", false},
-		{"1 != 2", true},
-		{"1.5 < 2.5", true},
-		{"1.5 > 2.5", false},
-		{"1.5 < 1.5", false},
-		{"1.5 > 1.5", false},
-		{"1.5 <= 2.5", true},
-		{"1.5 <= 1.5", true},
-		{"2.5 <= 1.5", false},
-		{"2.5 >= 1.5", true},
-		{"1.5 >= 1.5", true},
-		{"1.5 >= 2.5", false},
-		{"1.5 == 1.5", true},
-		{"1.5 != 1.5", false},
-		{"1.5 == 2.5", false},
-		{"1.5 != 2.5", true},
-		{"true == true", true},
-		{"false == false", true},
-		{"true == false", false},
-		{"false == true", false},
-		{"true != false", true},
-		{"false != true", true},
-		{"(1 < 2) == true", true},
-		{"(1 < 2) == false", false},
-		{"(1 > 2) == true", false},
-		{"(1 > 2) == false", true},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected bool
+		}{
+			{"true", true},
+			{"false", false},
+			{"1 < 2", true},
+			{"1 > 2", false},
+			{"1 < 1", false},
+			{"1 > 1", false},
+			{"1 <= 2", true},
+			{"0.0 / 0.0 == 0.0 / 0.0", false},
+			{"0.0 / 0.0 != 0.0 / 0.0", true},
+			{"1.0 / 0 > 10 ** 18", true},
+			{"-1.0 / 0 < -(10 ** 18)", true},
+			{"1.0 == 1", true},
+			{"1 <= 1", true},
+			{"2 <= 1", false},
+			{"2 >= 1", true},
+			{"1 >= 1", true},
+			{"1 >= 2", false},
+			{"1 == 1", true},
+			{"1 != 1", false},
+			{"1 == 2", false},
+			{"1 != 2", true},
+			{"1.5 < 2.5", true},
+			{"1.5 > 2.5", false},
+			{"1.5 < 1.5", false},
+			{"1.5 > 1.5", false},
+			{"1.5 <= 2.5", true},
+			{"1.5 <= 1.5", true},
+			{"2.5 <= 1.5", false},
+			{"2.5 >= 1.5", true},
+			{"1.5 >= 1.5", true},
+			{"1.5 >= 2.5", false},
+			{"1.5 == 1.5", true},
+			{"1.5 != 1.5", false},
+			{"1.5 == 2.5", false},
+			{"1.5 != 2.5", true},
+			{"true == true", true},
+			{"false == false", true},
+			{"true == false", false},
+			{"false == true", false},
+			{"true != false", true},
+			{"false != true", true},
+			{"(1 < 2) == true", true},
+			{"(1 < 2) == false", false},
+			{"(1 > 2) == true", false},
+			{"(1 > 2) == false", true},
+			{"true && true", true},
+			{"true && false", false},
+			{"false || true", true},
+			{"false || false", false},
+			{"1 && \"a\"", true},
+			{"0 || false", true},
+			{"1 < 2 && 2 < 3", true},
+			{"1 > 2 || 2 > 3", false},
+			{"false && (1 + true)", false},
+			{"true || (1 + true)", true},
+			{"let f = fn() { 1 + true }; false && f()", false},
+			{"let f = fn() { 1 + true }; true || f()", true},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testBooleanObject(t, evaluated, tt.expected)
-	}
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testBooleanObject(t, evaluated, tt.expected)
+		}
+	})
 }
 
 func TestStringLiteralExpression(t *testing.T) {
-	input := `"Hello World!`
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		input := `"Hello World!"`
 
-	evaluated := testEval(input)
-	testStringObject(t, evaluated, "Hello World!")
+		evaluated := eval(input)
+		testStringObject(t, evaluated, "Hello World!")
+	})
 }
 
 func TestStringConcatenation(t *testing.T) {
-	input := `"Hello" + " " + "World!"`
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		input := `"Hello" + " " + "World!"`
 
-	evaluated := testEval(input)
-	testStringObject(t, evaluated, "Hello World!")
+		evaluated := eval(input)
+		testStringObject(t, evaluated, "Hello World!")
+	})
 }
 
 func TestStringCompare(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected bool
-	}{
-		{`"foo" == "foo"`, true},
-		{`"foo" == "bar"`, false},
-		{`"foo" != "foo"`, false},
-		{`"foo" != "bar"`, true},
-		{`"foo" == "f" + "oo"`, true},
-		{`"f" + "o" + "o" == "foo"`, true},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected bool
+		}{
+			{`"foo" == "foo"`, true},
+			{`"foo" == "bar"`, false},
+			{`"foo" != "foo"`, false},
+			{`"foo" != "bar"`, true},
+			{`"foo" == "f" + "oo"`, true},
+			{`"f" + "o" + "o" == "foo"`, true},
+			{`"a" < "b"`, true},
+			{`"b" < "a"`, false},
+			{`"abc" > "abd"`, false},
+			{`"ab" < "abc"`, true},
+			{`"abc" <= "abc"`, true},
+			{`"abc" >= "abd"`, false},
+			{`"Z" < "a"`, true},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testBooleanObject(t, evaluated, tt.expected)
-	}
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testBooleanObject(t, evaluated, tt.expected)
+		}
+	})
+}
+
+func TestStructuralEquality(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"[1, 2] == [1, 2]", true},
+			{"[1, 2] != [1, 2]", false},
+			{"[1, 2] == [2, 1]", false},
+			{"[1, 2] == [1, 2, 3]", false},
+			{"[] == []", true},
+			{`[1, "a", [true, [2]]] == [1, "a", [true, [2]]]`, true},
+			{`[1, "a", [true, [2]]] == [1, "a", [true, [3]]]`, false},
+			{"[1, 2] == [1.0, 2.0]", true},
+			{"[10 ** 20] == [10 ** 20]", true},
+			{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+			{`{"a": 1} == {"a": 2}`, false},
+			{`{"a": 1} == {"b": 1}`, false},
+			{`{"a": 1} == {"a": 1, "b": 2}`, false},
+			{"{} == {}", true},
+			{"let f = fn() {}; [f] == [f]", true},
+			{"[fn() {}] == [fn() {}]", false},
+			{"puts() == if (false) { 1 }", true},
+			{"[puts()] == [1]", false},
+			{`[1] == 1`, false},
+			{`"1" == 1`, false},
+			{`"1" != 1`, true},
+			{"[1, 2] < [1, 3]", true},
+			{"[1, 2] > [1, 3]", false},
+			{"[1, 2] < [1, 2, 0]", true},
+			{"[2] > [1, 9]", true},
+			{"[1, 2] < [1, 2]", false},
+			{"[1, 2] <= [1, 2]", true},
+			{"[1, 2] >= [1, 2, 0]", false},
+			{"[] < [1]", true},
+			{`["a", "b"] < ["a", "c"]`, true},
+			{"[[1, 2], 3] < [[1, 3], 0]", true},
+			{`[1] < ["a"]`, "type mismatch: INTEGER < STRING"},
+			{`[1] < 1`, "type mismatch: ARRAY < INTEGER"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			switch expected := tt.expected.(type) {
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message: want=%q, got=%q", expected, errObj.Message)
+				}
+			}
+		}
+	})
+}
+
+func TestSelfReferentialEquality(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected bool
+		}{
+			{"let a = [1]; a[0] = a; let b = [1]; b[0] = b; a == b", true},
+			{"let a = [1, 2]; a[0] = a; let b = [1, 3]; b[0] = b; a == b", false},
+			{`let h = {}; h["h"] = h; let g = {}; g["h"] = g; h == g`, true},
+			{"let a = [1, [1]]; a[1][0] = a; let b = [1, [2]]; b[1][0] = b; a < b", false},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testBooleanObject(t, evaluated, tt.expected)
+		}
+	})
+}
+
+func TestSelfReferentialValues(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string // the value of a string or the message of an error
+		}{
+			{`let a = [1]; a[0] = a; "${a}"`, "[[...]]"},
+			{`let h = {}; h["h"] = h; "${h}"`, "{h: {...}}"},
+			{`let a = [1]; a[0] = a; "${sort([a, a])}"`, "[[[...]], [[...]]]"},
+			{`let a = [1]; a[0] = a; "${flatten([a], 0)}"`, "[[[...]]]"},
+			{`let a = [1]; a[0] = a; flatten(a, 1000000000)`, "argument 1 to 'flatten' must not contain itself"},
+			{"let a = [0, 1]; a[0] = a; let b = [0, 2]; b[0] = b; a < b", "cannot order arrays that contain themselves"},
+		}
+
+		for _, tt := range tests {
+			var actual string
+			switch evaluated := eval(tt.input).(type) {
+			case *object.String:
+				actual = evaluated.Value
+			case *object.Error:
+				actual = evaluated.Message
+			default:
+				t.Errorf("object is neither String nor Error: got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if actual != tt.expected {
+				t.Errorf("wrong result for %s: want=%q, got=%q", tt.input, tt.expected, actual)
+			}
+		}
+	})
+}
+
+func TestStringEscapes(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{`"a\tb\n"`, "a\tb\n"},
+			{`"\"quoted\""`, `"quoted"`},
+			{`"caf\u00e9"`, "café"},
+			{"`C:\\dir\\${x}`", `C:\dir\${x}`},
+			{"`line 1\nline 2`", "line 1\nline 2"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testStringObject(t, evaluated, tt.expected)
+		}
+	})
+}
+
+func TestStringInterpolation(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{`let name = "Monkey"; "Hello ${name}!"`, "Hello Monkey!"},
+			{`"${1 + 2} ${1.5} ${true} ${[1, "a"]}"`, "3 1.5 true [1, a]"},
+			{`let f = fn(x) { x * 2 }; "f(2) = ${f(2)}"`, "f(2) = 4"},
+			{`let h = {"k": "v"}; "${h["k"]}-${ {"a": 1}["a"] }"`, "v-1"},
+			{`let x = "in"; "out ${"in ${x}"}"`, "out in in"},
+			{`"${puts}"`, "builtin function"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testStringObject(t, evaluated, tt.expected)
+		}
+	})
+}
+
+func TestStringRepetition(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{`"ab" * 3`, "ababab"},
+			{`3 * "ab"`, "ababab"},
+			{`"ab" * 0`, ""},
+			{`"-" * 2 + "|"`, "--|"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testStringObject(t, evaluated, tt.expected)
+		}
+	})
+}
+
+func TestStringBuiltins(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`split("a,b,,c", ",")`, []string{"a", "b", "", "c"}},
+			{`split("  a b\tc\n")`, []string{"a", "b", "c"}},
+			{`split("日本語", "")`, []string{"日", "本", "語"}},
+			{`join(["a", "b", "c"], ", ")`, "a, b, c"},
+			{`join(["a", "b"])`, "ab"},
+			{`join([], "-")`, ""},
+			{`trim("  hi \n")`, "hi"},
+			{`trim("xxhixx", "x")`, "hi"},
+			{`upper("Hello")`, "HELLO"},
+			{`lower("HeLLo")`, "hello"},
+			{`upper("café")`, "CAFÉ"},
+			{`contains("hello", "ell")`, true},
+			{`contains("hello", "xyz")`, false},
+			{`contains("hello", "")`, true},
+			{`index_of("hello", "l")`, 2},
+			{`index_of("hello", "z")`, -1},
+			{`index_of("日本語", "語")`, 2},
+			{`replace("a-b-c", "-", "+")`, "a+b+c"},
+			{`replace("a-b-c", "-", "+", 1)`, "a+b-c"},
+			{`starts_with("monkey", "mon")`, true},
+			{`starts_with("monkey", "key")`, false},
+			{`ends_with("monkey", "key")`, true},
+			{`ends_with("monkey", "mon")`, false},
+			{`repeat("ab", 2)`, "abab"},
+			{`repeat("ab", 0)`, ""},
+			{`substr("hello", 1, 3)`, "ell"},
+			{`substr("hello", 2)`, "llo"},
+			{`substr("hello", -3, 2)`, "ll"},
+			{`substr("hello", 3, 10)`, "lo"},
+			{`substr("hello", 10)`, ""},
+			{`substr("hello", -10, 2)`, "he"},
+			{`substr("日本語です", 1, 2)`, "本語"},
+			{`format("%d + %d = %d", 1, 2, 3)`, "1 + 2 = 3"},
+			{`format("%s has %d items", "cart", 3)`, "cart has 3 items"},
+			{`format("%.2f|%5s|%-3d|%x", 3.14159, "ab", 7, 255)`, "3.14|   ab|7  |ff"},
+			{`format("%v %v %t", [1, 2], 2 ** 64, true)`, "[1, 2] 18446744073709551616 true"},
+			{`format("100%%")`, "100%"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case string:
+				testStringObject(t, evaluated, expected)
+			case []string:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("object is not Array: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if len(array.Elements) != len(expected) {
+					t.Errorf("array has wrong num of elements: want=%d, got=%d", len(expected), len(array.Elements))
+					continue
+				}
+				for i, e := range array.Elements {
+					testStringObject(t, e, expected[i])
+				}
+			}
+		}
+	})
+}
+
+func TestStringBuiltinErrors(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{`split(1, ",")`, "argument 1 to 'split' must be STRING, got=INTEGER"},
+			{`split("a", 1)`, "argument 2 to 'split' must be STRING, got=INTEGER"},
+			{`split()`, "wrong number of arguments: want=1..2, got=0"},
+			{`join("abc")`, "argument 1 to 'join' must be ARRAY, got=STRING"},
+			{`join(["a", 1])`, "argument 1 to 'join' must be an array of strings: got=INTEGER at index 1"},
+			{`upper("a", "b")`, "wrong number of arguments: want=1, got=2"},
+			{`replace("a", "b")`, "wrong number of arguments: want=3..4, got=2"},
+			{`replace("a", "b", "c", "d")`, "argument 4 to 'replace' must be INTEGER, got=STRING"},
+			{`repeat("ab", -1)`, "negative repeat count: -1"},
+			{`"ab" * -2`, "negative repeat count: -2"},
+			{`"ab" * 1000000000000`, "string too long: 2 bytes repeated 1000000000000 times"},
+			{`substr("abc", "1")`, "argument 2 to 'substr' must be INTEGER, got=STRING"},
+			{`format()`, "wrong number of arguments: want at least 1, got=0"},
+			{`format(1)`, "argument 1 to 'format' must be STRING, got=INTEGER"},
+			{`"a" - "b"`, "unknown operator: STRING - STRING"},
+			{`"a" * 1.5`, "type mismatch: STRING * FLOAT"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != tt.expected {
+				t.Errorf("wrong error message: want=%q, got=%q", tt.expected, errObj.Message)
+			}
+		}
+	})
 }
 
 func TestBangOperator(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected bool
-	}{
-		{"!true", false},
-		{"!false", true},
-		{"!5", false},
-		{"!!true", true},
-		{"!!false", false},
-		{"!!5", true},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected bool
+		}{
+			{"!true", false},
+			{"!false", true},
+			{"!5", false},
+			{"!!true", true},
+			{"!!false", false},
+			{"!!5", true},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testBooleanObject(t, evaluated, tt.expected)
-	}
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testBooleanObject(t, evaluated, tt.expected)
+		}
+	})
 }
 
 func TestIfElseExpressions(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected interface{}
-	}{
-		{"if (true) { 10 }", 10},
-		{"if (false) { 10 }", nil},
-		{"if (1) { 10 }", 10},
-		{"if (1 < 2) { 10 }", 10},
-		{"if (1 > 2) { 10 }", nil},
-		{"if (1 > 2) { 10 } else { 20 }", 20},
-		{"if (1 < 2) { 10 } else { 20 }", 10},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"if (true) { 10 }", 10},
+			{"if (false) { 10 }", nil},
+			{"if (1) { 10 }", 10},
+			{"if (1 < 2) { 10 }", 10},
+			{"if (1 > 2) { 10 }", nil},
+			{"if (1 > 2) { 10 } else { 20 }", 20},
+			{"if (1 < 2) { 10 } else { 20 }", 10},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		integer, ok := tt.expected.(int)
-		if ok {
-			testIntegerObject(t, evaluated, int64(integer))
-		} else {
-			testNullObject(t, evaluated)
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			integer, ok := tt.expected.(int)
+			if ok {
+				testIntegerObject(t, evaluated, int64(integer))
+			} else {
+				testNullObject(t, evaluated)
+			}
 		}
-	}
+	})
 }
 
 func TestReturnStatemets(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"return 10;", 10},
-		{"return 10; 9;", 10},
-		{"return 2 * 5; 9;", 10},
-		{"9; return 2 * 5; 9;", 10},
-		{`
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"return 10;", 10},
+			{"return 10; 9;", 10},
+			{"return 2 * 5; 9;", 10},
+			{"9; return 2 * 5; 9;", 10},
+			{`
 if (10 > 1) {
   if (10 > 1) {
     return 10;
@@ -216,14 +634,14 @@ if (10 > 1) {
   return 1;
 }
 `, 10},
-		{`
+			{`
 let f = fn(x) {
   return x;
   x + 10;
 };
 f(10);
 `, 10},
-		{`
+			{`
 let f = fn(x) {
   let result = x + 10;
   return result;
@@ -231,109 +649,576 @@ let f = fn(x) {
 };
 f(10);
 `, 20},
-	}
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
-	}
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testIntegerObject(t, evaluated, tt.expected)
+		}
+	})
 }
 
 func TestClosures(t *testing.T) {
-	input := `
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		input := `
 let newAdder = fn(x) {
   return fn(y) { x + y };
 };
 let addTwo = newAdder(2);
 addTwo(3);
 `
-	evaluated := testEval(input)
-	testIntegerObject(t, evaluated, 5)
+		evaluated := eval(input)
+		testIntegerObject(t, evaluated, 5)
+	})
 }
 
-func TestErrorHandling(t *testing.T) {
+// Tail calls are only eliminated by the evaluator; the vm limits calls to
+// vm.MaxFrames.
+func TestTailCalls(t *testing.T) {
 	tests := []struct {
-		input           string
-		expectedMessage string
+		input    string
+		expected int64
 	}{
 		{
-			"5 + true;",
-			"type mismatch: INTEGER + BOOLEAN",
-		},
-		{
-			"5 + true; 5;",
-			"type mismatch: INTEGER + BOOLEAN",
+			`let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } };
+sum(100000, 0);`,
+			5000050000,
 		},
 		{
-			"-true;",
-			"unknown operator: -BOOLEAN",
+			`let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); };
+sum(100000, 0);`,
+			5000050000,
 		},
 		{
-			"true + false;",
-			"unknown operator: BOOLEAN + BOOLEAN",
+			`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+if (even(100001)) { 1 } else { 0 };`,
+			0,
 		},
 		{
-			"5; true + false; 5;",
-			"unknown operator: BOOLEAN + BOOLEAN",
+			`let count = fn(arr, n) { if (len(arr) == 0) { return n; } count(rest(arr), n + 1) };
+let fill = fn(arr, n) { if (n == 0) { arr } else { fill(push(arr, n), n - 1) } };
+count(fill([], 2000), 0);`,
+			2000,
 		},
 		{
-			"if (10 > 1) { true + false; }",
-			"unknown operator: BOOLEAN + BOOLEAN",
+			`let loop = fn(n) { if (n == 0) { return 0; } loop(n - 1) };
+return loop(100000);`,
+			0,
 		},
-		{
-			`
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+// The vm limits calls to vm.MaxFrames, far less deep than the evaluator.
+func TestDeepRecursion(t *testing.T) {
+	input := `let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };
+f(20000);`
+
+	evaluated := testEval(input)
+	testIntegerObject(t, evaluated, 20000)
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	depth := evaluator.MaxCallDepth
+	evaluator.MaxCallDepth = 10
+	defer func() { evaluator.MaxCallDepth = depth }()
+
+	input := `let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };
+f(9) + f(10);`
+
+	evaluated := testEval(input)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+	}
+	expected := "ERROR: test.monkey:1:46: stack overflow"
+	if errObj.Inspect() != expected {
+		t.Errorf("wrong error: want=%q, got=%q", expected, errObj.Inspect())
+	}
+}
+
+// TestTailCallTraceback is evaluator-only, like TestTailCalls.
+func TestTailCallTraceback(t *testing.T) {
+	input := `let loop = fn(n) {
+  if (n == 0) { return n + true; }
+  loop(n - 1)
+};
+loop(1000);`
+
+	evaluated := testEval(input)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := "\tat loop (test.monkey:2:24)\n" +
+		"\tat loop (test.monkey:3:3)\n" +
+		"\tat <main> (test.monkey:5:1)\n"
+	if errObj.Traceback() != expected {
+		t.Errorf("wrong traceback: want=%q, got=%q", expected, errObj.Traceback())
+	}
+}
+
+func TestLoops(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"let i = 0; while (i < 10) { i = i + 1; } i;", 10},
+			{"let i = 0; while (true) { i = i + 1; if (i == 5) { break; } } i;", 5},
+			{"let i = 0; let n = 0; while (i < 10) { i = i + 1; if (i > 3) { continue; } n = n + i; } n;", 6},
+			{"let s = 0; for (let i = 0; i < 5; i = i + 1) { s = s + i; } s;", 10},
+			{"let s = 0; for (let i = 0; ; i = i + 1) { if (i == 4) { break; } s = s + i; } s;", 6},
+			{"let s = 0; for (let i = 0; i < 5; i = i + 1) { if (i == 2) { continue; } s = s + i; } s;", 8},
+			{"let s = 0; for (x in [1, 2, 3]) { s = s + x; } s;", 6},
+			{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } s = s + x; } s;", 3},
+			{"let s = 0; for (x in []) { s = s + 1; } s;", 0},
+			{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x * 10; } } 0 }; f([1, 2, 3]);", 20},
+			{"let n = 0; for (x in [1, 2]) { for (y in [1, 2, 3]) { if (y == 2) { break; } n = n + 1; } } n;", 2},
+			{"let s = 0; for (x in [1, 2]) { for (y in [3, 4]) { s = s + y; } s = s + x; } s;", 17},
+			{"let f = fn() { let s = 0; for (x in [1, 2, 3]) { for (y in [10, 20]) { if (y == 20) { continue; } s = s + x * y; } } s }; f();", 60},
+			{"let f = fn(n) { let i = 0; while (true) { if (i == n) { return i * 2; } i = i + 1; } }; f(4);", 8},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testIntegerObject(t, evaluated, tt.expected)
+		}
+	})
+}
+
+// The parser rejects break and continue inside an expression, so the
+// program is built by hand: while (true) { [if (true) { continue }]; }
+func TestLoopJumpInExpression(t *testing.T) {
+	tests := []struct {
+		jump     ast.Statement
+		expected string
+	}{
+		{&ast.BreakStatement{}, "break inside an expression"},
+		{&ast.ContinueStatement{}, "continue inside an expression"},
+	}
+
+	for _, tt := range tests {
+		ifExp := &ast.IfExpression{
+			Condition:   &ast.Boolean{Value: true},
+			Consequence: &ast.BlockStatement{Statements: []ast.Statement{tt.jump}},
+		}
+		program := &ast.Program{Statements: []ast.Statement{
+			&ast.WhileStatement{
+				Condition: &ast.Boolean{Value: true},
+				Body: &ast.BlockStatement{Statements: []ast.Statement{
+					&ast.ExpressionStatement{Expression: &ast.ArrayLiteral{Elements: []ast.Expression{ifExp}}},
+				}},
+			},
+		}}
+
+		evaluated := evaluator.Eval(program, object.NewEnvironment())
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message: want=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestLoopScopes(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"let i = 5; for (let i = 0; i < 2; i += 1) { } i;", 5},
+			{"let x = 10; for (x in [1, 2]) { } x;", 10},
+			{"let n = 1; let m = 0; while (n < 3) { let m = n; n += 1; } m;", 0},
+			{"let i = 5; for (i = 0; i < 2; i += 1) { } i;", 2},
+			{"let f = fn() { let x = 1; for (x in [7]) { let y = x; } x }; f();", 1},
+			{"let s = 0; for (x in [1, 2]) { let y = x * 10; s += y; } s;", 30},
+			{"for (let i = 0; i < 2; i += 1) { } i;", "identifier not found: i"},
+			{"for (x in [1]) { let y = x; } y;", "identifier not found: y"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message: want=%q, got=%q", expected, errObj.Message)
+				}
+			}
+		}
+	})
+}
+
+func TestAssignments(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"let x = 1; x = 2; x;", 2},
+			{"let x = 1; x = x + 2;", 3},
+			{"let x = 1; let y = 1; x = y = 5; x + y;", 10},
+			{"let x = 10; x += 5; x;", 15},
+			{"let x = 10; x -= 5; x;", 5},
+			{"let x = 10; x *= 5; x;", 50},
+			{"let x = 10; x /= 5; x;", 2},
+			{"let x = 1; let f = fn() { x = 2; }; f(); x;", 2},
+			{"let x = 1; let f = fn() { let x = 5; x = 2; }; f(); x;", 1},
+			{"let counter = fn() { let c = 0; fn() { c += 1 } }; let next = counter(); next(); next(); next();", 3},
+			{"let a = [1, 2, 3]; a[1] = 5; a[1];", 5},
+			{"let a = [1, 2, 3]; a[2] *= 3; a[2];", 9},
+			{"let a = [1, 2, 3]; let b = a; b[0] = 7; a[0];", 7},
+			{"let a = [1, 2, 3]; a[-1] = 4; a[2];", 4},
+			{"let a = [1, 2, 3]; let b = a[:]; b[0] = 7; a[0];", 1},
+			{`let h = {"k": 1}; h["k"] = 2; h["k"];`, 2},
+			{`let h = {}; h["n"] = 4; h["n"] += 1; h["n"];`, 5},
+			{`let h = {}; h.n = 6; h["n"];`, 6},
+			{"let sum = 0; each([1, 2, 3], fn(x) { sum += x }); sum;", 6},
+			{"let s = 0; for (let i = 0; i < 5; i += 1) { s += i; } s;", 10},
+			{"let i = 0; while (i < 3) { i = i + 1; } i;", 3},
+			{"let f = fn() { let c = 0; let g = fn() { fn() { c += 10 } }; g()(); c }; f();", 10},
+			{"let f = fn() { f = 5; 1 }; f(); f;", 5},
+			{"let g = fn() { let f = fn() { f = 7; 1 }; f(); f }; g();", 7},
+			{`let h = {"n": 1}; h.n += 2; h.n;`, 3},
+			{"let a = [1, 2]; (a[0] = 5) + a[0];", 10},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testIntegerObject(t, evaluated, tt.expected)
+		}
+	})
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{"x = 1;", "ERROR: test.monkey:1:1: cannot assign to undefined identifier: x"},
+			{"let f = fn() { y += 1 }; f();", "ERROR: test.monkey:1:16: identifier not found: y"},
+			{"let a = [1]; a[1] = 2;", "ERROR: test.monkey:1:14: index out of range: 1"},
+			{"let a = [1]; a[-2] = 2;", "ERROR: test.monkey:1:14: index out of range: -2"},
+			{"let a = 1; a[0] = 2;", "ERROR: test.monkey:1:12: index assignment not supported: INTEGER"},
+			{`let x = "a"; x -= 1;`, "ERROR: test.monkey:1:14: type mismatch: STRING - INTEGER"},
+			{"let h = {}; h[fn() {}] = 1;", "ERROR: test.monkey:1:13: unusable as hash key: FUNCTION"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Inspect() != tt.expected {
+				t.Errorf("wrong error: want=%q, got=%q", tt.expected, errObj.Inspect())
+			}
+		}
+	})
+}
+
+func TestLoopErrors(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{"for (x in 5) { x; }", "ERROR: test.monkey:1:1: cannot iterate over INTEGER"},
+			{"while (x) { 1; }", "ERROR: test.monkey:1:8: identifier not found: x"},
+			{"for (let i = 0; i < 3; i = i + true) { }", "ERROR: test.monkey:1:28: type mismatch: INTEGER + BOOLEAN"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Inspect() != tt.expected {
+				t.Errorf("wrong error: want=%q, got=%q", tt.expected, errObj.Inspect())
+			}
+		}
+	})
+}
+
+func TestErrorHandling(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input           string
+			expectedMessage string
+		}{
+			{
+				"5 + true;",
+				"type mismatch: INTEGER + BOOLEAN",
+			},
+			{
+				"5 + true; 5;",
+				"type mismatch: INTEGER + BOOLEAN",
+			},
+			{
+				"-true;",
+				"unknown operator: -BOOLEAN",
+			},
+			{
+				"~1.5;",
+				"unknown operator: ~FLOAT",
+			},
+			{
+				"1.5 & 2;",
+				"unknown operator: FLOAT & INTEGER",
+			},
+			{
+				"1 << -1;",
+				"negative shift count: -1",
+			},
+			{
+				"1 / 0;",
+				"division by zero: 1 / 0",
+			},
+			{
+				"2 ** 64 % 0;",
+				"division by zero: 18446744073709551616 % 0",
+			},
+			{
+				"2 ** 100000000000;",
+				"integer too large: 2 ** 100000000000",
+			},
+			{
+				"2 ** 64 + true;",
+				"type mismatch: BIG_INTEGER + BOOLEAN",
+			},
+			{
+				"let x = 0; 5 % x;",
+				"division by zero: 5 % 0",
+			},
+			{
+				"true && 1 + true;",
+				"type mismatch: INTEGER + BOOLEAN",
+			},
+			{
+				"true + false;",
+				"unknown operator: BOOLEAN + BOOLEAN",
+			},
+			{
+				"5; true + false; 5;",
+				"unknown operator: BOOLEAN + BOOLEAN",
+			},
+			{
+				"if (10 > 1) { true + false; }",
+				"unknown operator: BOOLEAN + BOOLEAN",
+			},
+			{
+				`
 if (10 > 1) {
   if (10 > 1) {
     return true + false;
   }
   return 1;
 }`,
-			"unknown operator: BOOLEAN + BOOLEAN",
-		},
-		{
-			"foobar",
-			"identifier not found: foobar",
-		},
-		{
-			`"Hello" - "World"`,
-			"unknown operator: STRING - STRING",
-		},
-		{
-			`{"name": "Monkey"}[fn(x) { x }];`,
-			"unusable as hash key: FUNCTION",
-		},
-	}
+				"unknown operator: BOOLEAN + BOOLEAN",
+			},
+			{
+				"foobar",
+				"identifier not found: foobar",
+			},
+			{
+				`"Hello" - "World"`,
+				"unknown operator: STRING - STRING",
+			},
+			{
+				`{"name": "Monkey"}[fn(x) { x }];`,
+				"unusable as hash key: FUNCTION",
+			},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if errObj.Message != tt.expectedMessage {
+				t.Errorf("wrong error message: want=%q, got=%q", tt.expectedMessage, errObj.Message)
+			}
+		}
+	})
+}
+
+// The vm delegates arithmetic to the evaluator, so CheckOverflow applies to
+// both engines.
+func TestCheckOverflow(t *testing.T) {
+	evaluator.CheckOverflow = true
+	defer func() { evaluator.CheckOverflow = false }()
+
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"9223372036854775807 + 1", "integer overflow: 9223372036854775807 + 1"},
+			{"-9223372036854775807 - 2", "integer overflow: -9223372036854775807 - 2"},
+			{"4611686018427387904 * 2", "integer overflow: 4611686018427387904 * 2"},
+			{"-1 * (-9223372036854775807 - 1)", "integer overflow: -1 * -9223372036854775808"},
+			{"(-9223372036854775807 - 1) / -1", "integer overflow: -9223372036854775808 / -1"},
+			{"-(-9223372036854775807 - 1)", "integer overflow: -(-9223372036854775808)"},
+			{"2 ** 63", "integer overflow: 2 ** 63"},
+			{"1 << 63", "integer overflow: 1 << 63"},
+			{"3 << 62", "integer overflow: 3 << 62"},
+			{"9223372036854775806 + 1", 9223372036854775807},
+			{"-9223372036854775807 - 1", -9223372036854775808},
+			{"4611686018427387903 * 2", 9223372036854775806},
+			{"2 ** 62", 4611686018427387904},
+			{"(-2) ** 63", -9223372036854775808},
+			{"-1 << 63", -9223372036854775808},
+			{"(-1) ** 1000001", -1},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message: want=%q, got=%q", expected, errObj.Message)
+				}
+			}
+		}
+	})
+}
+
+func TestErrorPositions(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{"5 + true;", "ERROR: test.monkey:1:1: type mismatch: INTEGER + BOOLEAN"},
+			{"let x = 1;\nlet y = x + foo;", "ERROR: test.monkey:2:13: identifier not found: foo"},
+			{"let f = fn() {\n  -true;\n};\nf();", "ERROR: test.monkey:2:3: unknown operator: -BOOLEAN"},
+			{`len(1)`, "ERROR: test.monkey:1:1: argument to 'len' not supported: got=INTEGER"},
+			{"let f = fn() { 1 + f() };\nf();", "ERROR: test.monkey:1:20: stack overflow"},
+			{"let f = fn(a, b) { a };\nf(1);", "ERROR: test.monkey:2:1: wrong number of arguments: want=2, got=1"},
+			{"let g = fn() { 1 };\nlet f = fn() { g(1) };\nf();", "ERROR: test.monkey:2:16: wrong number of arguments: want=0, got=1"},
+			{"map([1], fn(a, b) { a });", "ERROR: test.monkey:1:1: wrong number of arguments: want=2, got=1"},
+			{"map([1, 2], fn() { 1 });", "ERROR: test.monkey:1:1: wrong number of arguments: want=0, got=1"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Inspect() != tt.expected {
+				t.Errorf("wrong error: want=%q, got=%q", tt.expected, errObj.Inspect())
+			}
+		}
+	})
+}
+
+func TestErrorTraceback(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		input := `let inner = fn(x) {
+  x + true
+};
+let outer = fn(x) {
+  inner(x) * 2
+};
+outer(1);`
+
+		evaluated := eval(input)
 
 		errObj, ok := evaluated.(*object.Error)
 		if !ok {
-			t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
-			continue
+			t.Fatalf("no error object returned: got=%T (%+v)", evaluated, evaluated)
 		}
 
-		if errObj.Message != tt.expectedMessage {
-			t.Errorf("wrong error message: want=%q, got=%q", tt.expectedMessage, errObj.Message)
+		expected := "\tat inner (test.monkey:2:3)\n" +
+			"\tat outer (test.monkey:5:3)\n" +
+			"\tat <main> (test.monkey:7:1)\n"
+		if errObj.Traceback() != expected {
+			t.Errorf("wrong traceback: want=%q, got=%q", expected, errObj.Traceback())
 		}
-	}
+	})
+}
+
+func TestCallbackErrorTraceback(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		input := `let check = fn(x) {
+  x + true
+};
+let run = fn(arr) {
+  map(arr, check)
+};
+run([1]);`
+
+		evaluated := eval(input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+		}
+
+		expected := "\tat check (test.monkey:2:3)\n" +
+			"\tat run (test.monkey:5:3)\n" +
+			"\tat <main> (test.monkey:7:1)\n"
+		if errObj.Traceback() != expected {
+			t.Errorf("wrong traceback: want=%q, got=%q", expected, errObj.Traceback())
+		}
+	})
 }
 
 func TestLetStatements(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"let a = 5; a;", 5},
-		{"let a = 5 * 5; a;", 25},
-		{"let a = 5; let b = a; b;", 5},
-		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"let a = 5; a;", 5},
+			{"let a = 5 * 5; a;", 25},
+			{"let a = 5; let b = a; b;", 5},
+			{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
-	}
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testIntegerObject(t, evaluated, tt.expected)
+		}
+	})
 }
 
 func TestFunctionObject(t *testing.T) {
@@ -360,173 +1245,494 @@ func TestFunctionObject(t *testing.T) {
 }
 
 func TestFunctionApplication(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"let identity = fn(x) { x; }; identity(5);", 5},
-		{"let identity = fn(x) { return x; }; identity(5);", 5},
-		{"let double = fn(x) { x * 2; }; double(5);", 10},
-		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
-		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
-		{"fn(x) { x; }(5)", 5},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected int64
+		}{
+			{"let identity = fn(x) { x; }; identity(5);", 5},
+			{"let identity = fn(x) { return x; }; identity(5);", 5},
+			{"let double = fn(x) { x * 2; }; double(5);", 10},
+			{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
+			{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+			{"fn(x) { x; }(5)", 5},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		testIntegerObject(t, evaluated, tt.expected)
-	}
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			testIntegerObject(t, evaluated, tt.expected)
+		}
+	})
 }
 
 func TestBuiltinFunctions(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected interface{}
-	}{
-		{`len("")`, 0},
-		{`len("four")`, 4},
-		{`len("hello world")`, 11},
-		{`len(1)`, "argument to 'len' not supported: got=INTEGER"},
-		{`len("one", "two")`, "wrong number of arguments: want=1, got=2"},
-		{`len()`, "wrong number of arguments: want=1, got=0"},
-		{`len([])`, 0},
-		{`len([1, 2, 3])`, 3},
-		{`len(["foo bar", ["foo", "bar"]])`, 2},
-		{`first([])`, nil},
-		{`first([1])`, 1},
-		{`first([1, 2, 3])`, 1},
-		{`first([[1, 2], [3, 4]])`, []int{1, 2}},
-		{`first(0)`, "argument to 'first' not supported: got=INTEGER"},
-		{`first()`, "wrong number of arguments: want=1, got=0"},
-		{`first([1, 2], [3, 4])`, "wrong number of arguments: want=1, got=2"},
-		{`last([])`, nil},
-		{`last([1])`, 1},
-		{`last([1, 2, 3])`, 3},
-		{`last([[1, 2], [3, 4]])`, []int{3, 4}},
-		{`last(0)`, "argument to 'last' not supported: got=INTEGER"},
-		{`last()`, "wrong number of arguments: want=1, got=0"},
-		{`last([1, 2], [3, 4])`, "wrong number of arguments: want=1, got=2"},
-		{`rest([])`, nil},
-		{`rest([1])`, []int{}},
-		{`rest([1, 2])`, []int{2}},
-		{`rest([1, 2, 3])`, []int{2, 3}},
-		{`rest(0)`, "argument to 'rest' not supported: got=INTEGER"},
-		{`rest()`, "wrong number of arguments: want=1, got=0"},
-		{`rest([1, 2], [3, 4])`, "wrong number of arguments: want=1, got=2"},
-		{`push([1, 2, 3, 4], 5)`, []int{1, 2, 3, 4, 5}},
-		{`push([], 1)`, []int{1}},
-		{`push()`, "wrong number of arguments: want=2, got=0"},
-		{`push([])`, "wrong number of arguments: want=2, got=1"},
-		{`push([], 1, 2)`, "wrong number of arguments: want=2, got=3"},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`len("")`, 0},
+			{`len("four")`, 4},
+			{`len("hello world")`, 11},
+			{`len("日本語")`, 3},
+			{`len("café")`, 4},
+			{`len(1)`, "argument to 'len' not supported: got=INTEGER"},
+			{`len("one", "two")`, "wrong number of arguments: want=1, got=2"},
+			{`len()`, "wrong number of arguments: want=1, got=0"},
+			{`len([])`, 0},
+			{`len([1, 2, 3])`, 3},
+			{`len(["foo bar", ["foo", "bar"]])`, 2},
+			{`first([])`, nil},
+			{`first([1])`, 1},
+			{`first([1, 2, 3])`, 1},
+			{`first([[1, 2], [3, 4]])`, []int{1, 2}},
+			{`first(0)`, "argument to 'first' not supported: got=INTEGER"},
+			{`first()`, "wrong number of arguments: want=1, got=0"},
+			{`first([1, 2], [3, 4])`, "wrong number of arguments: want=1, got=2"},
+			{`last([])`, nil},
+			{`last([1])`, 1},
+			{`last([1, 2, 3])`, 3},
+			{`last([[1, 2], [3, 4]])`, []int{3, 4}},
+			{`last(0)`, "argument to 'last' not supported: got=INTEGER"},
+			{`last()`, "wrong number of arguments: want=1, got=0"},
+			{`last([1, 2], [3, 4])`, "wrong number of arguments: want=1, got=2"},
+			{`rest([])`, nil},
+			{`rest([1])`, []int{}},
+			{`rest([1, 2])`, []int{2}},
+			{`rest([1, 2, 3])`, []int{2, 3}},
+			{`rest(0)`, "argument to 'rest' not supported: got=INTEGER"},
+			{`rest()`, "wrong number of arguments: want=1, got=0"},
+			{`rest([1, 2], [3, 4])`, "wrong number of arguments: want=1, got=2"},
+			{`push([1, 2, 3, 4], 5)`, []int{1, 2, 3, 4, 5}},
+			{`push([], 1)`, []int{1}},
+			{`push()`, "wrong number of arguments: want=2, got=0"},
+			{`push([])`, "wrong number of arguments: want=2, got=1"},
+			{`push([], 1, 2)`, "wrong number of arguments: want=2, got=3"},
+			{`byte_len("日本語")`, 9},
+			{`byte_len("abc")`, 3},
+			{`byte_len([])`, "argument to 'byte_len' not supported: got=ARRAY"},
+			{`bytes("é!")`, []int{195, 169, 33}},
+			{`bytes("")`, []int{}},
+			{`bytes(1)`, "argument to 'bytes' not supported: got=INTEGER"},
+			{`len(from_bytes([230, 151, 165]))`, 1},
+			{`from_bytes([256])`, "argument to 'from_bytes' must be an array of bytes: got=256 at index 0"},
+			{`from_bytes("a")`, "argument to 'from_bytes' not supported: got=STRING"},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
 
-		switch expected := tt.expected.(type) {
-		case int:
-			testIntegerObject(t, evaluated, int64(expected))
-		case string:
-			errObj, ok := evaluated.(*object.Error)
-			if !ok {
-				t.Errorf("object is not Error: got=%T (%+v)", evaluated, evaluated)
-				continue
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("object is not Error: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message: want=%q, got=%q", expected, errObj.Message)
+				}
+			case []int:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("object is not Array: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if len(array.Elements) != len(expected) {
+					t.Errorf("array has wrong num of elements: want=%d, got=%d", len(expected), len(array.Elements))
+				}
+				for i, e := range array.Elements {
+					testIntegerObject(t, e, int64(expected[i]))
+				}
+			case nil:
+				testNullObject(t, evaluated)
 			}
-			if errObj.Message != expected {
-				t.Errorf("wrong error message: want=%q, got=%q", expected, errObj.Message)
+		}
+	})
+}
+
+func TestBuiltinExit(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"exit()", 0},
+			{"exit(3)", 3},
+			{"let f = fn(x) { exit(x) }; f(255); 1", 255},
+			{"map([7, 8], fn(x) { exit(x) })", 7},
+			{"exit(256)", "exit code out of range: 256"},
+			{"exit(-1)", "exit code out of range: -1"},
+			{`exit("1")`, "argument 1 to 'exit' must be INTEGER, got=STRING"},
+			{"exit(1, 2)", "wrong number of arguments: want=0..1, got=2"},
+		}
+
+		for _, tt := range tests {
+			evaluated, code := evalExit(eval, tt.input)
+
+			switch expected := tt.expected.(type) {
+			case int:
+				if code != expected {
+					t.Errorf("wrong exit code for %q: want=%d, got=%d (%v)", tt.input, expected, code, evaluated)
+				}
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("object is not Error: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message: want=%q, got=%q", expected, errObj.Message)
+				}
 			}
-		case []int:
-			array, ok := evaluated.(*object.Array)
+		}
+	})
+}
+
+// evalExit evaluates input and returns the code passed to exit, or -1 if
+// exit is not called.
+func evalExit(eval evalFunc, input string) (evaluated object.Object, code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			code = r.(evaluator.Exit).Code
+		}
+	}()
+	return eval(input), -1
+}
+
+func TestArrayBuiltins(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+			{`map([], fn(x) { x * 2 })`, []int{}},
+			{`map(["a", "bc"], len)`, []int{1, 2}},
+			{`let n = 10; map([1, 2], fn(x) { x + n })`, []int{11, 12}},
+			{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, []int{2, 4}},
+			{`reduce([1, 2, 3, 4], fn(acc, x) { acc + x })`, 10},
+			{`reduce([1, 2, 3], fn(acc, x) { acc * x }, 10)`, 60},
+			{`reduce([], fn(acc, x) { acc + x }, 0)`, 0},
+			{`each([1], fn(x) { x })`, nil},
+			{`find([1, 2, 3, 4], fn(x) { x > 2 })`, 3},
+			{`find([1, 2], fn(x) { x > 2 })`, nil},
+			{`any([1, 2, 3], fn(x) { x > 2 })`, true},
+			{`any([], fn(x) { true })`, false},
+			{`all([1, 2, 3], fn(x) { x > 0 })`, true},
+			{`all([1, 2, 3], fn(x) { x > 1 })`, false},
+			{`sort([3, 1, 2])`, []int{1, 2, 3}},
+			{`sort([3, 1, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+			{`sort([3, 1, 2], fn(a, b) { b - a })`, []int{3, 2, 1}},
+			{`map(sort([[2, 1], [1, 2], [2, 0]], fn(a, b) { a[0] < b[0] }), fn(p) { p[1] })`, []int{2, 1, 0}},
+			{`let a = [2, 1]; sort(a); a`, []int{2, 1}},
+			{`index_of(sort(["b", "c", "a"]), "c")`, 2},
+			{`reverse([1, 2, 3])`, []int{3, 2, 1}},
+			{`len(zip([1, 2, 3], [4, 5]))`, 2},
+			{`zip([1, 2, 3], [4, 5])[1]`, []int{2, 5}},
+			{`range(4)`, []int{0, 1, 2, 3}},
+			{`range(2, 5)`, []int{2, 3, 4}},
+			{`range(0, 10, 3)`, []int{0, 3, 6, 9}},
+			{`range(5, 0, -2)`, []int{5, 3, 1}},
+			{`range(3, 1)`, []int{}},
+			{`flatten([1, [2, 3], [], [4, [5]]])[4]`, []int{5}},
+			{`len(flatten([1, [2, 3], [], [4, [5]]]))`, 5},
+			{`flatten([1, [2, [3, [4]]]], 10)`, []int{1, 2, 3, 4}},
+			{`concat([1], [], [2, 3])`, []int{1, 2, 3}},
+			{`concat()`, []int{}},
+			{`contains([1, 2, 3], 2)`, true},
+			{`contains([1, 2, 3], "2")`, false},
+			{`contains([1.0, 2.0], 1)`, true},
+			{`index_of([1, 2, 3], 3)`, 2},
+			{`index_of([1, 2, 3], 4)`, -1},
+			{`index_of(["a", "b"], "b")`, 1},
+			{`let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) } }; reduce(map(range(100), f), fn(a, b) { a + b })`, 0},
+			{`map([1, 2], fn(x) { return x * 3; 0 })`, []int{3, 6}},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case []int:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("object is not Array: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if len(array.Elements) != len(expected) {
+					t.Errorf("array has wrong num of elements: want=%d, got=%d", len(expected), len(array.Elements))
+					continue
+				}
+				for i, e := range array.Elements {
+					testIntegerObject(t, e, int64(expected[i]))
+				}
+			case nil:
+				testNullObject(t, evaluated)
+			}
+		}
+	})
+}
+
+func TestArrayBuiltinErrors(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{`map(1, fn(x) { x })`, "argument 1 to 'map' must be ARRAY, got=INTEGER"},
+			{`map([1], 1)`, "argument 2 to 'map' must be FUNCTION, got=INTEGER"},
+			{`map([1])`, "wrong number of arguments: want=2, got=1"},
+			{`map([1, "a"], fn(x) { x + 1 })`, "type mismatch: STRING + INTEGER"},
+			{`filter([1], fn(x) { x + true })`, "type mismatch: INTEGER + BOOLEAN"},
+			{`reduce([], fn(a, b) { a + b })`, "reduce of empty array with no initial value"},
+			{`each([1, 2], fn(x) { if (x == 2) { x + true } })`, "type mismatch: INTEGER + BOOLEAN"},
+			{`sort([1, "a"])`, "type mismatch: STRING < INTEGER"},
+			{`sort([1, 2], fn(a, b) { "x" })`, "sort comparator must return BOOLEAN or INTEGER, got=STRING"},
+			{`reverse(1)`, "argument to 'reverse' not supported: got=INTEGER"},
+			{`zip([1], 2)`, "argument 2 to 'zip' must be ARRAY, got=INTEGER"},
+			{`range(1, 2, 0)`, "range step must not be 0"},
+			{`range("a")`, "argument 1 to 'range' must be INTEGER, got=STRING"},
+			{`range(10000000000)`, "range too large: 0 to 10000000000 by 1"},
+			{`concat([1], 2)`, "argument 2 to 'concat' must be ARRAY, got=INTEGER"},
+			{`contains(1, 1)`, "argument to 'contains' not supported: got=INTEGER"},
+			{`index_of("abc", 1)`, "argument 2 to 'index_of' must be STRING, got=INTEGER"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+
+			errObj, ok := evaluated.(*object.Error)
 			if !ok {
-				t.Errorf("object is not Array: got=%T (%+v)", evaluated, evaluated)
+				t.Errorf("no error object returned for %s: got=%T (%+v)", tt.input, evaluated, evaluated)
 				continue
 			}
-			if len(array.Elements) != len(expected) {
-				t.Errorf("array has wrong num of elements: want=%d, got=%d", len(expected), len(array.Elements))
+			if errObj.Message != tt.expected {
+				t.Errorf("wrong error message: want=%q, got=%q", tt.expected, errObj.Message)
 			}
-			for i, e := range array.Elements {
-				testIntegerObject(t, e, int64(expected[i]))
-			}
-		case nil:
-			testNullObject(t, evaluated)
 		}
-	}
+	})
 }
 
 func TestArrayLiterals(t *testing.T) {
-	input := `[1, 2 * 2, 3 + 3]`
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		input := `[1, 2 * 2, 3 + 3]`
 
-	evaluated := testEval(input)
-	array, ok := evaluated.(*object.Array)
-	if !ok {
-		t.Fatalf("object is not Array: got=%T (%+v)", evaluated, evaluated)
-	}
+		evaluated := eval(input)
+		array, ok := evaluated.(*object.Array)
+		if !ok {
+			t.Fatalf("object is not Array: got=%T (%+v)", evaluated, evaluated)
+		}
 
-	if len(array.Elements) != 3 {
-		t.Fatalf("array has wrong num of elements: want=3, got=%d", len(array.Elements))
-	}
+		if len(array.Elements) != 3 {
+			t.Fatalf("array has wrong num of elements: want=3, got=%d", len(array.Elements))
+		}
+
+		testIntegerObject(t, array.Elements[0], 1)
+		testIntegerObject(t, array.Elements[1], 4)
+		testIntegerObject(t, array.Elements[2], 6)
+	})
+}
 
-	testIntegerObject(t, array.Elements[0], 1)
-	testIntegerObject(t, array.Elements[1], 4)
-	testIntegerObject(t, array.Elements[2], 6)
+func TestStringIndexExpressions(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{`"abc"[0]`, "a"},
+			{`"abc"[2]`, "c"},
+			{`"日本語"[1]`, "本"},
+			{`let s = "こんにちは"; s[len(s) - 1]`, "は"},
+			{`from_bytes([230, 151, 165])`, "日"},
+			{`"abc"[-1]`, "c"},
+			{`"日本語"[-3]`, "日"},
+			{`"abc"[3]`, nil},
+			{`"abc"[-4]`, nil},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			if expected, ok := tt.expected.(string); ok {
+				testStringObject(t, evaluated, expected)
+			} else {
+				testNullObject(t, evaluated)
+			}
+		}
+	})
 }
 
 func TestArrayIndexExpressions(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected interface{}
-	}{
-		{
-			"[1, 2, 3][0]",
-			1,
-		},
-		{
-			"[1, 2, 3][1]",
-			2,
-		},
-		{
-			"[1, 2, 3][2]",
-			3,
-		},
-		{
-			"let i = 0; [1][i]",
-			1,
-		},
-		{
-			"[1, 2, 3][1 + 1]",
-			3,
-		},
-		{
-			"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];",
-			6,
-		},
-		{
-			"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i];",
-			2,
-		},
-		{
-			"[1, 2, 3][3]",
-			nil,
-		},
-		{
-			"[1, 2, 3][-1]",
-			nil,
-		},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{
+				"[1, 2, 3][0]",
+				1,
+			},
+			{
+				"[1, 2, 3][1]",
+				2,
+			},
+			{
+				"[1, 2, 3][2]",
+				3,
+			},
+			{
+				"let i = 0; [1][i]",
+				1,
+			},
+			{
+				"[1, 2, 3][1 + 1]",
+				3,
+			},
+			{
+				"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];",
+				6,
+			},
+			{
+				"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i];",
+				2,
+			},
+			{
+				"[1, 2, 3][3]",
+				nil,
+			},
+			{
+				"[1, 2, 3][-1]",
+				3,
+			},
+			{
+				"[1, 2, 3][-3]",
+				1,
+			},
+			{
+				"[1, 2, 3][-4]",
+				nil,
+			},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		integer, ok := tt.expected.(int)
-		if ok {
-			testIntegerObject(t, evaluated, int64(integer))
-		} else {
-			testNullObject(t, evaluated)
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			integer, ok := tt.expected.(int)
+			if ok {
+				testIntegerObject(t, evaluated, int64(integer))
+			} else {
+				testNullObject(t, evaluated)
+			}
 		}
-	}
+	})
+}
+
+func TestSliceExpressions(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"[1, 2, 3, 4, 5][1:3]", []int{2, 3}},
+			{"[1, 2, 3, 4, 5][:2]", []int{1, 2}},
+			{"[1, 2, 3, 4, 5][3:]", []int{4, 5}},
+			{"[1, 2, 3, 4, 5][:]", []int{1, 2, 3, 4, 5}},
+			{"[1, 2, 3, 4, 5][-2:]", []int{4, 5}},
+			{"[1, 2, 3, 4, 5][:-1]", []int{1, 2, 3, 4}},
+			{"let n = 2; let a = [1, 2, 3, 4, 5]; a[n - 1:len(a) - n]", []int{2, 3}},
+			{"[1, 2, 3][1:10]", []int{2, 3}},
+			{"[1, 2, 3][-10:1]", []int{1}},
+			{"[1, 2, 3][5:]", []int{}},
+			{"[1, 2, 3][2:1]", []int{}},
+			{`"hello"[1:3]`, "el"},
+			{`"hello"[2:]`, "llo"},
+			{`"hello"[:-1]`, "hell"},
+			{`"日本語"[1:]`, "本語"},
+			{`"abc"[5:]`, ""},
+			{`[1, 2][true:]`, "slice index must be INTEGER, got=BOOLEAN"},
+			{`{}[1:2]`, "slice operator not supported: HASH"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			switch expected := tt.expected.(type) {
+			case []int:
+				array, ok := evaluated.(*object.Array)
+				if !ok {
+					t.Errorf("object is not Array: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if len(array.Elements) != len(expected) {
+					t.Errorf("array has wrong num of elements: want=%d, got=%d", len(expected), len(array.Elements))
+					continue
+				}
+				for i, e := range array.Elements {
+					testIntegerObject(t, e, int64(expected[i]))
+				}
+			case string:
+				if errObj, ok := evaluated.(*object.Error); ok {
+					if errObj.Message != expected {
+						t.Errorf("wrong error message: want=%q, got=%q", expected, errObj.Message)
+					}
+					continue
+				}
+				testStringObject(t, evaluated, expected)
+			}
+		}
+	})
+}
+
+// The vm delegates indexing to the evaluator, so StrictIndex applies to both
+// engines.
+func TestStrictIndex(t *testing.T) {
+	evaluator.StrictIndex = true
+	defer func() { evaluator.StrictIndex = false }()
+
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{"[1, 2, 3][3]", "index out of range: 3"},
+			{"[1, 2, 3][-4]", "index out of range: -4"},
+			{`"abc"[3]`, "index out of range: 3"},
+			{"[1, 2, 3][1:5]", "slice bounds out of range: [1:5] with length 3"},
+			{"[1, 2, 3][-4:]", "slice bounds out of range: [-4:] with length 3"},
+			{`"abc"[2:1]`, "slice bounds out of range: [2:1] with length 3"},
+			{"[1, 2, 3][-1]", 3},
+			{"len([1, 2, 3][0:3])", 3},
+			{`{"a": 1}["b"]`, nil},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message: want=%q, got=%q", expected, errObj.Message)
+				}
+			case nil:
+				testNullObject(t, evaluated)
+			}
+		}
+	})
 }
 
 func TestHashLiterals(t *testing.T) {
-	input := `
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		input := `
 let two = "two";
 {
 	"one": 10 - 9,
@@ -537,89 +1743,256 @@ let two = "two";
 	false: 6
 }
 `
-	evaluated := testEval(input)
-	result, ok := evaluated.(*object.Hash)
-	if !ok {
-		t.Errorf("object is not *object.Hash: got=%T (%+v)", evaluated, evaluated)
-	}
+		evaluated := eval(input)
+		result, ok := evaluated.(*object.Hash)
+		if !ok {
+			t.Errorf("object is not *object.Hash: got=%T (%+v)", evaluated, evaluated)
+		}
 
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
-	}
+		expected := map[object.Hashable]int64{
+			&object.String{Value: "one"}:   1,
+			&object.String{Value: "two"}:   2,
+			&object.String{Value: "three"}: 3,
+			&object.Integer{Value: 4}:      4,
+			evaluator.TRUE:                 5,
+			evaluator.FALSE:                6,
+		}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs: want=%d, got=%d", len(expected), len(result.Pairs))
-	}
+		if result.Len() != len(expected) {
+			t.Fatalf("Hash has wrong num of pairs: want=%d, got=%d", len(expected), result.Len())
+		}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
-		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+		for expectedKey, expectedValue := range expected {
+			pair, ok := result.Get(expectedKey)
+			if !ok {
+				t.Errorf("no pair for given key in Pairs")
+			}
+
+			testIntegerObject(t, pair.Value, expectedValue)
 		}
+	})
+}
 
-		testIntegerObject(t, pair.Value, expectedValue)
-	}
+func TestHashOrder(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{`{"b": 1, "a": 2, "c": 3}`, "{b: 1, a: 2, c: 3}"},
+			{`{3: "x", 1: "y", 2: "z"}`, "{3: x, 1: y, 2: z}"},
+			{`{"a": 1, "b": 2, "a": 3}`, "{a: 3, b: 2}"},
+			{`keys({"z": 1, "y": 2, "x": 3})`, "[z, y, x]"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			if evaluated.Inspect() != tt.expected {
+				t.Errorf("wrong result for %s: want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+			}
+		}
+	})
+}
+
+func TestHashBuiltins(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{`keys({"a": 1, 2: "b"})`, "[a, 2]"},
+			{`keys({})`, "[]"},
+			{`values({"a": 1, 2: "b"})`, "[1, b]"},
+			{`entries({"a": 1, "b": 2})`, "[[a, 1], [b, 2]]"},
+			{`has_key({"a": 1}, "a")`, "true"},
+			{`has_key({"a": 1}, "b")`, "false"},
+			{`has_key({1: 1}, 1.0)`, "true"},
+			{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "{a: 1, c: 3}"},
+			{`delete({"a": 1}, "x")`, "{a: 1}"},
+			{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+			{`merge({"a": 1, "b": 2}, {"b": 3, "c": 4})`, "{a: 1, b: 3, c: 4}"},
+			{`merge()`, "{}"},
+			{`hash([["a", 1], ["b", 2]])`, "{a: 1, b: 2}"},
+			{`hash(zip(["x", "y"], [1, 2]))`, "{x: 1, y: 2}"},
+			{`hash(entries({"k": "v", 1: 2}))`, "{k: v, 1: 2}"},
+			{`len({"a": 1, "b": 2})`, "2"},
+			{`len({})`, "0"},
+			{`keys(1)`, "ERROR: argument 1 to 'keys' must be HASH, got=INTEGER"},
+			{`has_key({}, [fn() {}])`, "ERROR: unusable as hash key: ARRAY"},
+			{`delete({})`, "ERROR: wrong number of arguments: want=2, got=1"},
+			{`merge({}, [])`, "ERROR: argument 2 to 'merge' must be HASH, got=ARRAY"},
+			{`hash([["a", 1], ["b"]])`, "ERROR: argument 1 to 'hash' must be an array of [key, value] pairs: got=[b] at index 1"},
+			{`hash([[fn() {}, 1]])`, "ERROR: unusable as hash key: FUNCTION"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			if errObj, ok := evaluated.(*object.Error); ok {
+				evaluated = &object.String{Value: "ERROR: " + errObj.Message}
+			}
+			if evaluated.Inspect() != tt.expected {
+				t.Errorf("wrong result for %s: want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+			}
+		}
+	})
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{`let h = {}; h[[0, 0]] = "a"; h[[0, 1]] = "b"; h[[0, 0]] = "c"; h`, "{[0, 0]: c, [0, 1]: b}"},
+			{`let k = [1]; let h = {k: "v"}; k[0] = 2; [h[[1]], h[k]]`, "[v, null]"},
+			{`let h = {[1]: "v"}; let k = keys(h)[0]; k[0] = 2; h[[1]]`, "v"},
+			{`len({[1]: 1, [1.0]: 2})`, "1"},
+			{`has_key({[1, 2]: 1}, [1, 2])`, "true"},
+			{`delete({[1]: 1, [2]: 2}, [1])`, "{[2]: 2}"},
+			{`{[fn() {}]: 1}`, "ERROR: test.monkey:1:1: unusable as hash key: ARRAY"},
+			{`let a = [1]; a[0] = a; {a: 1}`, "ERROR: test.monkey:1:24: unusable as hash key: ARRAY"},
+		}
+
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			if evaluated.Inspect() != tt.expected {
+				t.Errorf("wrong result for %s: want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+			}
+		}
+	})
 }
 
 func TestHashIndexExpressions(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected interface{}
-	}{
-		{
-			`{"foo": 5}["foo"]`,
-			5,
-		},
-		{
-			`{"foo": 5}["bar"]`,
-			nil,
-		},
-		{
-			`let key = "foo"; {"foo": 5}[key]`,
-			5,
-		},
-		{
-			`{}["foo"]`,
-			nil,
-		},
-		{
-			`{5: 5}[5]`,
-			5,
-		},
-		{
-			`{true: 5}[true]`,
-			5,
-		},
-		{
-			`{false: 5}[false]`,
-			5,
-		},
-	}
+	runEngines(t, func(t *testing.T, eval evalFunc) {
+		tests := []struct {
+			input    string
+			expected interface{}
+		}{
+			{
+				`{"foo": 5}["foo"]`,
+				5,
+			},
+			{
+				`{"foo": 5}["bar"]`,
+				nil,
+			},
+			{
+				`let key = "foo"; {"foo": 5}[key]`,
+				5,
+			},
+			{
+				`{}["foo"]`,
+				nil,
+			},
+			{
+				`{5: 5}[5]`,
+				5,
+			},
+			{
+				`{true: 5}[true]`,
+				5,
+			},
+			{
+				`{false: 5}[false]`,
+				5,
+			},
+			{
+				`{1.5: 5}[1.5]`,
+				5,
+			},
+			{
+				`{1: 5}[1.0]`,
+				5,
+			},
+			{
+				`{2.0: 5}[2]`,
+				5,
+			},
+			{
+				`{2.0: 5}[2.5]`,
+				nil,
+			},
+			{
+				`{2 ** 64: 5}[2.0 ** 64]`,
+				5,
+			},
+			{
+				`{[1, 2]: 5}[[1, 2]]`,
+				5,
+			},
+			{
+				`{[1, 2]: 5}[[2, 1]]`,
+				nil,
+			},
+			{
+				`let x = 1; let y = 2; {[x, y]: 5}[[1, 2.0]]`,
+				5,
+			},
+			{
+				`{[1, ["a"]]: 5}[[1, ["a"]]]`,
+				5,
+			},
+			{
+				`{{"a": 1, "b": 2}: 5}[{"b": 2, "a": 1}]`,
+				5,
+			},
+			{
+				`{{"a": 1}: 5}[{"a": 2}]`,
+				nil,
+			},
+		}
 
-	for _, tt := range tests {
-		evaluated := testEval(tt.input)
-		integer, ok := tt.expected.(int)
-		if ok {
-			testIntegerObject(t, evaluated, int64(integer))
-		} else {
-			testNullObject(t, evaluated)
+		for _, tt := range tests {
+			evaluated := eval(tt.input)
+			integer, ok := tt.expected.(int)
+			if ok {
+				testIntegerObject(t, evaluated, int64(integer))
+			} else {
+				testNullObject(t, evaluated)
+			}
 		}
-	}
+	})
 }
 
 // helper functions
 
+type evalFunc func(input string) object.Object
+
+// runEngines runs f once for each execution engine.
+func runEngines(t *testing.T, f func(t *testing.T, eval evalFunc)) {
+	engines := []struct {
+		name string
+		eval evalFunc
+	}{
+		{"evaluator", testEval},
+		{"vm", testRun},
+	}
+	for _, e := range engines {
+		e := e
+		t.Run(e.name, func(t *testing.T) {
+			f(t, e.eval)
+		})
+	}
+}
+
 func testEval(input string) object.Object {
-	l := lexer.New(input)
+	l := lexer.NewFile("test.monkey", input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	env := object.NewEnvironment()
-	return Eval(program, env)
+	return evaluator.Eval(program, env)
+}
+
+func testRun(input string) object.Object {
+	l := lexer.NewFile("test.monkey", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		compileErr := err.(*compiler.Error)
+		return &object.Error{Message: compileErr.Message, Pos: compileErr.Pos}
+	}
+	return vm.New(c.Bytecode()).Run()
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -649,6 +2022,19 @@ func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
 	return true
 }
 
+func testBigIntObject(t *testing.T, obj object.Object, expected string) bool {
+	result, ok := obj.(*object.BigInt)
+	if !ok {
+		t.Errorf("object is not BigInt: got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value.String() != expected {
+		t.Errorf("object has wrong value: want=%s, got=%s", expected, result.Value)
+		return false
+	}
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -676,7 +2062,7 @@ func testStringObject(t *testing.T, obj object.Object, expected string) bool {
 }
 
 func testNullObject(t *testing.T, obj object.Object) bool {
-	if obj != NULL {
+	if obj != evaluator.NULL {
 		t.Errorf("object is not null: got=%T (%+v)", obj, obj)
 		return false
 	}
@@ -0,0 +1,102 @@
+package evaluator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/lusingander/monkey/ast"
+	"github.com/lusingander/monkey/lexer"
+	"github.com/lusingander/monkey/object"
+	"github.com/lusingander/monkey/parser"
+)
+
+var (
+	// modules caches the imported modules by their absolute path.
+	modules = make(map[string]*object.Module)
+
+	// importing holds the paths of the modules being evaluated, to detect
+	// import cycles.
+	importing []string
+)
+
+// EnterFile marks the program in filename as being evaluated, so that an
+// import of it from one of its modules is reported as a cycle instead of
+// running it again as a module. The returned function undoes it.
+func EnterFile(filename string) func() {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return func() {}
+	}
+	n := len(importing)
+	importing = append(importing, path)
+	return func() {
+		// PanicError may have cleared importing already
+		if len(importing) > n {
+			importing = importing[:n]
+		}
+	}
+}
+
+func evalImportExpression(node *ast.ImportExpression) object.Object {
+	// a relative path is resolved against the directory of the importing file
+	filename := node.Path.Value
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(filepath.Dir(node.Pos().Filename), filename)
+	}
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return newError("could not import %q: %s", node.Path.Value, err)
+	}
+
+	if module, ok := modules[path]; ok {
+		return module
+	}
+	for i, p := range importing {
+		if p == path {
+			cycle := append(append([]string(nil), importing[i:]...), path)
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	input, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return newError("could not import %q: %s", node.Path.Value, err)
+	}
+
+	p := parser.New(lexer.NewFile(filename, string(input)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return newError("could not import %q:\n\t%s", node.Path.Value, strings.Join(p.Errors(), "\n\t"))
+	}
+
+	importing = append(importing, path)
+	defer func() {
+		importing = importing[:len(importing)-1]
+	}()
+
+	env := object.NewEnvironment()
+	macroEnv := object.NewEnvironment()
+	DefineMacros(program, macroEnv)
+	expanded := ExpandMacros(program, macroEnv)
+	if result := Eval(expanded, env); isError(result) {
+		return result
+	}
+
+	module := &object.Module{Path: path, Env: env}
+	modules[path] = module
+	return module
+}
+
+func evalModuleIndexExpression(module, index object.Object) object.Object {
+	moduleObject := module.(*object.Module)
+	name, ok := index.(*object.String)
+	if !ok {
+		return newError("module index must be STRING, got %s", index.Type())
+	}
+	value, ok := moduleObject.Env.Exported(name.Value)
+	if !ok {
+		return newError("%s is not exported by %s", name.Value, moduleObject.Path)
+	}
+	return value
+}
@@ -0,0 +1,142 @@
+package evaluator_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lusingander/monkey/evaluator"
+	"github.com/lusingander/monkey/lexer"
+	"github.com/lusingander/monkey/object"
+	"github.com/lusingander/monkey/parser"
+)
+
+func TestImport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"lib.monkey": `
+let secret = 40;
+export let add = fn(a, b) { a + b };
+export let answer = add(secret, 2);
+`,
+		"sub/a.monkey": `export let b = import "b.monkey";`,
+		"sub/b.monkey": `export let value = 7;`,
+	})
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let lib = import "lib.monkey"; lib.add(1, 2);`, 3},
+		{`let lib = import "lib.monkey"; lib["answer"];`, 42},
+		{`(import "lib.monkey").answer;`, 42},
+		{`let a = import "sub/a.monkey"; a.b.value;`, 7},
+		{`import "lib.monkey" == import "./lib.monkey";`, true},
+		{`let h = {"x": 5}; h.x;`, 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := evalFile(dir, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"lib.monkey":    `let secret = 1; export let open = 2;`,
+		"a.monkey":      `export let b = import "b.monkey";`,
+		"b.monkey":      `export let a = import "a.monkey";`,
+		"broken.monkey": `let x 1;`,
+	})
+	a := filepath.Join(dir, "a.monkey")
+	b := filepath.Join(dir, "b.monkey")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let lib = import "lib.monkey"; lib.secret;`,
+			"secret is not exported by " + filepath.Join(dir, "lib.monkey"),
+		},
+		{
+			`import "lib.monkey"[0];`,
+			"module index must be STRING, got INTEGER",
+		},
+		{
+			`import "a.monkey";`,
+			"import cycle: " + a + " -> " + b + " -> " + a,
+		},
+		{
+			`import "broken.monkey";`,
+			"could not import \"broken.monkey\":\n\t" + filepath.Join(dir, "broken.monkey") + ":1:7: expected next token to be =, got INT instead",
+		},
+		{
+			`let f = fn() { export let x = 1; }; f();`,
+			"export is only allowed at the top level",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := evalFile(dir, tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message: want=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestImportCycleThroughEntryFile(t *testing.T) {
+	input := `import "lib.monkey";`
+	dir := writeFiles(t, map[string]string{
+		"main.monkey": input,
+		"lib.monkey":  `export let main = import "main.monkey";`,
+	})
+	main := filepath.Join(dir, "main.monkey")
+	lib := filepath.Join(dir, "lib.monkey")
+
+	leave := evaluator.EnterFile(main)
+	evaluated := evalFile(dir, input)
+	leave()
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned: got=%T (%+v)", evaluated, evaluated)
+	}
+	expected := "import cycle: " + main + " -> " + lib + " -> " + main
+	if errObj.Message != expected {
+		t.Errorf("wrong error message: want=%q, got=%q", expected, errObj.Message)
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func evalFile(dir, input string) object.Object {
+	l := lexer.NewFile(filepath.Join(dir, "main.monkey"), input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment()
+	return evaluator.Eval(program, env)
+}
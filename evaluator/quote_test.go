@@ -1,4 +1,4 @@
-package evaluator
+package evaluator_test
 
 import (
 	"testing"
@@ -42,6 +42,23 @@ func TestQuote(t *testing.T) {
 	}
 }
 
+func TestQuoteEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"quote(1 + 2) == quote(1 + 2)", true},
+		{"quote(1 + 2) == quote(1 + 3)", false},
+		{"quote(unquote(1 + 1)) == quote(2)", true},
+		{"quote(1) == 1", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestQuoteUnquote(t *testing.T) {
 	tests := []struct {
 		input    string
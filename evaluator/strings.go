@@ -0,0 +1,292 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lusingander/monkey/object"
+)
+
+// maxStringBytes bounds the size of strings built by repetition, which
+// could otherwise exhaust memory with a single expression.
+const maxStringBytes = 1 << 28
+
+func repeatString(s string, n int64) object.Object {
+	if n < 0 {
+		return newError("negative repeat count: %d", n)
+	}
+	if n > 0 && int64(len(s)) > maxStringBytes/n {
+		return newError("string too long: %d bytes repeated %d times", len(s), n)
+	}
+	return &object.String{Value: strings.Repeat(s, int(n))}
+}
+
+// String builtins take and return indices and lengths in characters,
+// like len and the index operator.
+
+func builtinSplit(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 2); err != nil {
+		return err
+	}
+	s, err := stringArg("split", args, 0)
+	if err != nil {
+		return err
+	}
+	var parts []string
+	if len(args) == 1 {
+		parts = strings.Fields(s)
+	} else {
+		sep, err := stringArg("split", args, 1)
+		if err != nil {
+			return err
+		}
+		parts = strings.Split(s, sep)
+	}
+	elems := make([]object.Object, len(parts))
+	for i, p := range parts {
+		elems[i] = &object.String{Value: p}
+	}
+	return &object.Array{Elements: elems}
+}
+
+func builtinJoin(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 2); err != nil {
+		return err
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return argumentError("join", 0, object.ArrayObj, args[0])
+	}
+	sep := ""
+	if len(args) == 2 {
+		var err *object.Error
+		if sep, err = stringArg("join", args, 1); err != nil {
+			return err
+		}
+	}
+	strs := make([]string, len(arr.Elements))
+	for i, e := range arr.Elements {
+		str, ok := e.(*object.String)
+		if !ok {
+			return newError("argument 1 to 'join' must be an array of strings: got=%s at index %d", e.Type(), i)
+		}
+		strs[i] = str.Value
+	}
+	return &object.String{Value: strings.Join(strs, sep)}
+}
+
+func builtinTrim(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 2); err != nil {
+		return err
+	}
+	s, err := stringArg("trim", args, 0)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		return &object.String{Value: strings.TrimSpace(s)}
+	}
+	cutset, err := stringArg("trim", args, 1)
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: strings.Trim(s, cutset)}
+}
+
+func builtinUpper(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	s, err := stringArg("upper", args, 0)
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: strings.ToUpper(s)}
+}
+
+func builtinLower(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	s, err := stringArg("lower", args, 0)
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: strings.ToLower(s)}
+}
+
+func builtinReplace(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 3, 4); err != nil {
+		return err
+	}
+	s, err := stringArg("replace", args, 0)
+	if err != nil {
+		return err
+	}
+	old, err := stringArg("replace", args, 1)
+	if err != nil {
+		return err
+	}
+	with, err := stringArg("replace", args, 2)
+	if err != nil {
+		return err
+	}
+	n := int64(-1)
+	if len(args) == 4 {
+		if n, err = integerArg("replace", args, 3); err != nil {
+			return err
+		}
+	}
+	return &object.String{Value: strings.Replace(s, old, with, int(n))}
+}
+
+func builtinStartsWith(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 2, 2); err != nil {
+		return err
+	}
+	s, prefix, err := stringArgs2("starts_with", args)
+	if err != nil {
+		return err
+	}
+	return nativeBoolToBooleanObject(strings.HasPrefix(s, prefix))
+}
+
+func builtinEndsWith(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 2, 2); err != nil {
+		return err
+	}
+	s, suffix, err := stringArgs2("ends_with", args)
+	if err != nil {
+		return err
+	}
+	return nativeBoolToBooleanObject(strings.HasSuffix(s, suffix))
+}
+
+func builtinRepeat(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 2, 2); err != nil {
+		return err
+	}
+	s, err := stringArg("repeat", args, 0)
+	if err != nil {
+		return err
+	}
+	n, err := integerArg("repeat", args, 1)
+	if err != nil {
+		return err
+	}
+	return repeatString(s, n)
+}
+
+// builtinSubstr returns length characters of s starting at start, or the
+// rest of s if length is omitted. A negative start counts from the end of
+// s, and the range is clamped to s.
+func builtinSubstr(args ...object.Object) object.Object {
+	if err := checkArgCount(args, 2, 3); err != nil {
+		return err
+	}
+	s, err := stringArg("substr", args, 0)
+	if err != nil {
+		return err
+	}
+	start, err := integerArg("substr", args, 1)
+	if err != nil {
+		return err
+	}
+	runes := []rune(s)
+	n := int64(len(runes))
+	length := n
+	if len(args) == 3 {
+		if length, err = integerArg("substr", args, 2); err != nil {
+			return err
+		}
+	}
+	if start < 0 {
+		start += n
+	}
+	start = clamp(start, 0, n)
+	end := clamp(start+clamp(length, 0, n), start, n)
+	return &object.String{Value: string(runes[start:end])}
+}
+
+// builtinFormat formats its arguments like Go's fmt.Sprintf. Integers,
+// floats, strings and booleans are passed as the corresponding Go values,
+// other objects as the string shown by Inspect.
+func builtinFormat(args ...object.Object) object.Object {
+	if len(args) < 1 {
+		return newError("wrong number of arguments: want at least 1, got=%d", len(args))
+	}
+	format, err := stringArg("format", args, 0)
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		switch arg := arg.(type) {
+		case *object.Integer:
+			values[i] = arg.Value
+		case *object.BigInt:
+			values[i] = arg.Value
+		case *object.Float:
+			values[i] = arg.Value
+		case *object.String:
+			values[i] = arg.Value
+		case *object.Boolean:
+			values[i] = arg.Value
+		default:
+			values[i] = arg.Inspect()
+		}
+	}
+	return &object.String{Value: fmt.Sprintf(format, values...)}
+}
+
+func clamp(v, min, max int64) int64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func checkArgCount(args []object.Object, min, max int) *object.Error {
+	if len(args) >= min && len(args) <= max {
+		return nil
+	}
+	if min == max {
+		return newError("wrong number of arguments: want=%d, got=%d", min, len(args))
+	}
+	return newError("wrong number of arguments: want=%d..%d, got=%d", min, max, len(args))
+}
+
+func argumentError(name string, i int, want object.ObjectType, got object.Object) *object.Error {
+	return newError("argument %d to '%s' must be %s, got=%s", i+1, name, want, got.Type())
+}
+
+func stringArg(name string, args []object.Object, i int) (string, *object.Error) {
+	str, ok := args[i].(*object.String)
+	if !ok {
+		return "", argumentError(name, i, object.StringObj, args[i])
+	}
+	return str.Value, nil
+}
+
+func stringArgs2(name string, args []object.Object) (string, string, *object.Error) {
+	a, err := stringArg(name, args, 0)
+	if err != nil {
+		return "", "", err
+	}
+	b, err := stringArg(name, args, 1)
+	if err != nil {
+		return "", "", err
+	}
+	return a, b, nil
+}
+
+func integerArg(name string, args []object.Object, i int) (int64, *object.Error) {
+	integer, ok := args[i].(*object.Integer)
+	if !ok {
+		return 0, argumentError(name, i, object.IntegerObj, args[i])
+	}
+	return integer.Value, nil
+}
@@ -2,4 +2,7 @@ module github.com/lusingander/monkey
 
 go 1.15
 
-require github.com/urfave/cli/v2 v2.3.0
+require (
+	github.com/peterh/liner v1.2.2
+	github.com/urfave/cli/v2 v2.3.0
+)
@@ -1,31 +1,78 @@
 package lexer
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/lusingander/monkey/token"
 )
 
+// Lexer splits the input into tokens. It reads the input as UTF-8, so
+// ch is a whole character and columns count characters, not bytes.
 type Lexer struct {
 	input        string
-	position     int
-	readPosition int
-	ch           byte
+	filename     string
+	position     int // offset of ch
+	readPosition int // offset after ch
+	ch           rune
+	line         int
+	column       int
+
+	// interpolations holds, for each ${ being lexed, the number of braces
+	// opened inside it that are not closed yet.
+	interpolations []int
+
+	errors map[int]*Error
+}
+
+// Error describes why the lexer produced an ILLEGAL token.
+type Error struct {
+	Pos token.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
 }
 
 func New(input string) *Lexer {
+	return NewFile("", input)
+}
+
+// NewFile returns a Lexer whose token positions refer to filename.
+func NewFile(filename, input string) *Lexer {
 	l := &Lexer{
-		input: input,
+		input:    input,
+		filename: filename,
+		line:     1,
+		errors:   make(map[int]*Error),
 	}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-
 	l.skip()
 
+	pos := l.pos()
+	tok := l.readToken()
+	tok.Pos = pos
+	tok.End = l.pos()
+	return tok
+}
+
+// Err returns the error that made tok ILLEGAL, or nil if the token is
+// illegal on its own, like a stray character.
+func (l *Lexer) Err(tok token.Token) *Error {
+	return l.errors[tok.Pos.Offset]
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -40,27 +87,72 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharsToken(token.PLUS_ASSIGN)
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharsToken(token.MINUS_ASSIGN)
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharsToken(token.ASTERISK_ASSIGN)
+		} else if l.peekChar() == '*' {
+			tok = l.newTwoCharsToken(token.POWER)
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.newTwoCharsToken(token.SLASH_ASSIGN)
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.newTwoCharsToken(token.AND)
+		} else {
+			tok = newToken(token.AMPERSAND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.newTwoCharsToken(token.OR)
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
+	case '^':
+		tok = newToken(token.CARET, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
 	case '<':
 		if l.peekChar() == '=' {
 			tok = l.newTwoCharsToken(token.LE)
+		} else if l.peekChar() == '<' {
+			tok = l.newTwoCharsToken(token.LSHIFT)
 		} else {
 			tok = newToken(token.LT, l.ch)
 		}
 	case '>':
 		if l.peekChar() == '=' {
 			tok = l.newTwoCharsToken(token.GE)
+		} else if l.peekChar() == '>' {
+			tok = l.newTwoCharsToken(token.RSHIFT)
 		} else {
 			tok = newToken(token.GT, l.ch)
 		}
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case '.':
+		if isDigit(l.peekChar()) {
+			return l.newNumberToken()
+		}
+		tok = newToken(token.DOT, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case ';':
@@ -70,16 +162,28 @@ func (l *Lexer) NextToken() token.Token {
 	case ')':
 		tok = newToken(token.RPAREN, l.ch)
 	case '{':
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.interpolations); n > 0 {
+			if l.interpolations[n-1] == 0 {
+				l.interpolations = l.interpolations[:n-1]
+				tok = l.readString(token.STRING_MIDDLE, token.STRING_TAIL)
+				break
+			}
+			l.interpolations[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
 		tok = newToken(token.RBRACKET, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readString(token.STRING_HEAD, token.STRING)
+	case '`':
+		tok = l.readRawString()
 	case 0:
 		tok.Type = token.EOF
 		tok.Literal = ""
@@ -101,18 +205,41 @@ func (l *Lexer) NextToken() token.Token {
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+	l.position = l.readPosition
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
-	} else {
-		l.ch = l.input[l.readPosition]
+		l.readPosition++
+		return
 	}
-	l.position = l.readPosition
-	l.readPosition++
+	r, size := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	l.ch = r
+	l.readPosition += size
 }
 
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+// readNumber reads a numeric literal: digits, letters for base prefixes
+// and hex digits, underscores, dots and signed exponents. The parser
+// reports malformed literals.
 func (l *Lexer) readNumber() string {
 	position := l.position
-	for isDigit(l.ch) || l.ch == '.' {
+	prefixed := l.ch == '0' && strings.ContainsRune("xXbBoO", l.peekChar())
+	for isLetter(l.ch) || isDigit(l.ch) || l.ch == '.' {
+		if !prefixed && (l.ch == 'e' || l.ch == 'E') && (l.peekChar() == '+' || l.peekChar() == '-') {
+			l.readChar()
+		}
 		l.readChar()
 	}
 	return l.input[position:l.position]
@@ -144,22 +271,141 @@ func (l *Lexer) skip() {
 	}
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	r, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return r
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
+// readString reads the rest of a string literal after its opening quote,
+// or after the brace closing an interpolation, decoding escape sequences.
+// It returns an end token at the closing quote, or an interp token at the
+// ${ starting the next interpolation.
+func (l *Lexer) readString(interp, end token.TokenType) token.Token {
+	start, pos := l.position, l.pos()
+	var out strings.Builder
+	var err *Error
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch {
+		case l.ch == '"':
+			tok := token.Token{Type: end, Literal: out.String()}
+			if err != nil {
+				tok = l.illegal(start, err)
+			}
+			return tok
+		case l.ch == '$' && l.peekChar() == '{':
+			l.readChar()
+			l.interpolations = append(l.interpolations, 0)
+			tok := token.Token{Type: interp, Literal: out.String()}
+			if err != nil {
+				tok = l.illegal(start, err)
+			}
+			return tok
+		case l.ch == '\\':
+			if e := l.readEscape(&out); e != nil && err == nil {
+				err = e
+			}
+		case l.ch == 0:
+			return l.illegal(start, &Error{Pos: pos, Msg: "unterminated string literal"})
+		default:
+			// copy the source bytes, which may not be valid UTF-8
+			out.WriteString(l.input[l.position:l.readPosition])
 		}
 	}
-	return l.input[position:l.position]
+}
+
+// readEscape decodes the escape sequence starting at the backslash under
+// the cursor and leaves the cursor on its last character.
+func (l *Lexer) readEscape(out *strings.Builder) *Error {
+	pos := l.pos()
+	if l.peekChar() == 0 {
+		// reported as an unterminated string
+		return nil
+	}
+	if isNewLine(l.peekChar()) {
+		return &Error{Pos: pos, Msg: "unknown escape sequence: \\ at the end of a line"}
+	}
+	l.readChar()
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '0':
+		out.WriteByte(0)
+	case '\\', '"', '\'', '$':
+		out.WriteRune(l.ch)
+	case 'x':
+		b, ok := l.readHex(2, 2)
+		if !ok {
+			return &Error{Pos: pos, Msg: "invalid escape sequence: \\x must be followed by 2 hex digits"}
+		}
+		out.WriteByte(byte(b))
+	case 'u':
+		var r uint64
+		var ok bool
+		if l.peekChar() == '{' {
+			l.readChar()
+			r, ok = l.readHex(1, 6)
+			ok = ok && l.peekChar() == '}'
+			if ok {
+				l.readChar()
+			}
+		} else {
+			r, ok = l.readHex(4, 4)
+		}
+		if !ok {
+			return &Error{Pos: pos, Msg: "invalid escape sequence: \\u must be followed by 4 hex digits or {hex digits}"}
+		}
+		if !utf8.ValidRune(rune(r)) {
+			return &Error{Pos: pos, Msg: fmt.Sprintf("invalid unicode code point: U+%04X", r)}
+		}
+		out.WriteRune(rune(r))
+	default:
+		return &Error{Pos: pos, Msg: fmt.Sprintf("unknown escape sequence: \\%c", l.ch)}
+	}
+	return nil
+}
+
+// readHex reads between min and max hex digits following the cursor.
+func (l *Lexer) readHex(min, max int) (uint64, bool) {
+	start := l.readPosition
+	for l.readPosition-start < max && isHexDigit(l.peekChar()) {
+		l.readChar()
+	}
+	digits := l.input[start:l.readPosition]
+	if len(digits) < min {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(digits, 16, 32)
+	return v, err == nil
+}
+
+// readRawString reads a backquoted string. Raw strings may span lines and
+// have neither escape sequences nor interpolation.
+func (l *Lexer) readRawString() token.Token {
+	start, pos := l.position, l.pos()
+	for {
+		l.readChar()
+		switch l.ch {
+		case '`':
+			return token.Token{Type: token.STRING, Literal: l.input[start+1 : l.position]}
+		case 0:
+			return l.illegal(start, &Error{Pos: pos, Msg: "unterminated raw string literal"})
+		}
+	}
+}
+
+// illegal returns an ILLEGAL token for the source from offset start up to
+// the cursor and records err as the reason.
+func (l *Lexer) illegal(start int, err *Error) token.Token {
+	l.errors[start] = err
+	return token.Token{Type: token.ILLEGAL, Literal: l.input[start:l.position]}
 }
 
 func (l *Lexer) newTwoCharsToken(tokenType token.TokenType) token.Token {
@@ -171,7 +417,7 @@ func (l *Lexer) newTwoCharsToken(tokenType token.TokenType) token.Token {
 	}
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{
 		Type:    tokenType,
 		Literal: string(ch),
@@ -180,28 +426,36 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 
 func (l *Lexer) newNumberToken() token.Token {
 	lit := l.readNumber()
-	n := strings.Count(lit, ".")
-	if n == 0 {
+	if len(lit) > 1 && lit[0] == '0' && strings.ContainsRune("xXbBoO", rune(lit[1])) {
 		return token.Token{Type: token.INT, Literal: lit}
 	}
-	if n == 1 {
+	n := strings.Count(lit, ".")
+	if n > 1 {
+		return token.Token{Type: token.ILLEGAL, Literal: lit}
+	}
+	if n == 1 || strings.ContainsAny(lit, "eE") {
 		return token.Token{Type: token.FLOAT, Literal: lit}
 	}
-	return token.Token{Type: token.ILLEGAL, Literal: lit}
+	return token.Token{Type: token.INT, Literal: lit}
 }
 
-func isLetter(ch byte) bool {
-	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ch == '_'
+func isLetter(ch rune) bool {
+	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ch == '_' ||
+		(ch >= utf8.RuneSelf && unicode.IsLetter(ch))
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func isNewLine(ch byte) bool {
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
+func isNewLine(ch rune) bool {
 	return ch == '\n' || ch == '\r'
 }
 
-func isWhitespace(ch byte) bool {
+func isWhitespace(ch rune) bool {
 	return ch == ' ' || ch == '\t' || isNewLine(ch)
 }
@@ -43,6 +43,13 @@ if (5 < 10) {
 {"foo": "bar"};
 
 macro(x, y) { x + y; };
+
+export let m = import "lib.monkey";
+m.f;
+
+x = 1; x += 2; x -= 3; x *= 4; x /= 5;
+a % b ** c && d || e & f | g ^ ~h << i >> j;
+123456789012345678901234567890;
 `
 
 	tests := []struct {
@@ -166,6 +173,60 @@ macro(x, y) { x + y; };
 		{token.SEMICOLON, ";"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		{token.EXPORT, "export"},
+		{token.LET, "let"},
+		{token.IDENT, "m"},
+		{token.ASSIGN, "="},
+		{token.IMPORT, "import"},
+		{token.STRING, "lib.monkey"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "m"},
+		{token.DOT, "."},
+		{token.IDENT, "f"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "3"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "4"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "b"},
+		{token.POWER, "**"},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.AMPERSAND, "&"},
+		{token.IDENT, "f"},
+		{token.PIPE, "|"},
+		{token.IDENT, "g"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.IDENT, "h"},
+		{token.LSHIFT, "<<"},
+		{token.IDENT, "i"},
+		{token.RSHIFT, ">>"},
+		{token.IDENT, "j"},
+		{token.SEMICOLON, ";"},
+		{token.INT, "123456789012345678901234567890"},
+		{token.SEMICOLON, ";"},
 		{token.EOF, ""},
 	}
 
@@ -183,3 +244,227 @@ macro(x, y) { x + y; };
 		}
 	}
 }
+
+func TestNextTokenPosition(t *testing.T) {
+	input := `let x = 5;
+  x + "ab";`
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+		expectedEndCol int
+	}{
+		{token.LET, 1, 1, 4},
+		{token.IDENT, 1, 5, 6},
+		{token.ASSIGN, 1, 7, 8},
+		{token.INT, 1, 9, 10},
+		{token.SEMICOLON, 1, 10, 11},
+		{token.IDENT, 2, 3, 4},
+		{token.PLUS, 2, 5, 6},
+		{token.STRING, 2, 7, 11},
+		{token.SEMICOLON, 2, 11, 12},
+		{token.EOF, 2, 12, 13},
+	}
+
+	l := NewFile("test.monkey", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("[%d] wrong type; expected = %q, got = %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.Filename != "test.monkey" {
+			t.Fatalf("[%d] wrong filename; expected = %q, got = %q", i, "test.monkey", tok.Pos.Filename)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("[%d] wrong position; expected = %d:%d, got = %d:%d", i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+		if tok.End.Line != tt.expectedLine || tok.End.Column != tt.expectedEndCol {
+			t.Fatalf("[%d] wrong end position; expected = %d:%d, got = %d:%d", i, tt.expectedLine, tt.expectedEndCol, tok.End.Line, tok.End.Column)
+		}
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{"42", token.INT, "42"},
+		{"1_000_000", token.INT, "1_000_000"},
+		{"0x1F", token.INT, "0x1F"},
+		{"0xdeadBEEF", token.INT, "0xdeadBEEF"},
+		{"0b1010", token.INT, "0b1010"},
+		{"0o755", token.INT, "0o755"},
+		{"3.14", token.FLOAT, "3.14"},
+		{".5", token.FLOAT, ".5"},
+		{"1e10", token.FLOAT, "1e10"},
+		{"1E10", token.FLOAT, "1E10"},
+		{"2.5e-3", token.FLOAT, "2.5e-3"},
+		{"6e+2", token.FLOAT, "6e+2"},
+		{"1_000.000_1", token.FLOAT, "1_000.000_1"},
+		{"1.2.3", token.ILLEGAL, "1.2.3"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input + ";")
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("[%d] wrong type; expected = %q, got = %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("[%d] wrong literal; expected = %q, got = %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.SEMICOLON {
+			t.Fatalf("[%d] number not fully read; next token = %q", i, next.Literal)
+		}
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Token
+	}{
+		{`"a\tb\nc"`, []token.Token{{Type: token.STRING, Literal: "a\tb\nc"}}},
+		{`"say \"hi\" \\ \'"`, []token.Token{{Type: token.STRING, Literal: `say "hi" \ '`}}},
+		{`"\x41é\u{1F600}\0"`, []token.Token{{Type: token.STRING, Literal: "Aé\U0001F600\x00"}}},
+		{`"é"`, []token.Token{{Type: token.STRING, Literal: "é"}}},
+		{"\"two\nlines\"", []token.Token{{Type: token.STRING, Literal: "two\nlines"}}},
+		{"\"a\n${b}\n\"", []token.Token{
+			{Type: token.STRING_HEAD, Literal: "a\n"},
+			{Type: token.IDENT, Literal: "b"},
+			{Type: token.STRING_TAIL, Literal: "\n"},
+		}},
+		{`"cost: \${x}"`, []token.Token{{Type: token.STRING, Literal: "cost: ${x}"}}},
+		{`"$x {y}"`, []token.Token{{Type: token.STRING, Literal: "$x {y}"}}},
+		{"`raw\\n\n${x}\"`", []token.Token{{Type: token.STRING, Literal: "raw\\n\n${x}\""}}},
+		{
+			`"Hello ${name}!"`,
+			[]token.Token{
+				{Type: token.STRING_HEAD, Literal: "Hello "},
+				{Type: token.IDENT, Literal: "name"},
+				{Type: token.STRING_TAIL, Literal: "!"},
+			},
+		},
+		{
+			`"${a}${ {"k": "${b}"}["k"] }"`,
+			[]token.Token{
+				{Type: token.STRING_HEAD, Literal: ""},
+				{Type: token.IDENT, Literal: "a"},
+				{Type: token.STRING_MIDDLE, Literal: ""},
+				{Type: token.LBRACE, Literal: "{"},
+				{Type: token.STRING, Literal: "k"},
+				{Type: token.COLON, Literal: ":"},
+				{Type: token.STRING_HEAD, Literal: ""},
+				{Type: token.IDENT, Literal: "b"},
+				{Type: token.STRING_TAIL, Literal: ""},
+				{Type: token.RBRACE, Literal: "}"},
+				{Type: token.LBRACKET, Literal: "["},
+				{Type: token.STRING, Literal: "k"},
+				{Type: token.RBRACKET, Literal: "]"},
+				{Type: token.STRING_TAIL, Literal: ""},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		for j, expected := range append(tt.expected, token.Token{Type: token.EOF}) {
+			tok := l.NextToken()
+			if tok.Type != expected.Type {
+				t.Fatalf("[%d:%d] wrong type; expected = %q, got = %q", i, j, expected.Type, tok.Type)
+			}
+			if tok.Literal != expected.Literal {
+				t.Fatalf("[%d:%d] wrong literal; expected = %q, got = %q", i, j, expected.Literal, tok.Literal)
+			}
+		}
+	}
+}
+
+func TestIllegalStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`x = "abc`, "test.monkey:1:5: unterminated string literal"},
+		{"\"abc\n", "test.monkey:1:1: unterminated string literal"},
+		{"\"a\\\nb\"", `test.monkey:1:3: unknown escape sequence: \ at the end of a line`},
+		{"`abc\n", "test.monkey:1:1: unterminated raw string literal"},
+		{`"${x}abc`, "test.monkey:1:5: unterminated string literal"},
+		{`"a\qb"`, `test.monkey:1:3: unknown escape sequence: \q`},
+		{`"\x4"`, `test.monkey:1:2: invalid escape sequence: \x must be followed by 2 hex digits`},
+		{`"\u{110000}"`, "test.monkey:1:2: invalid unicode code point: U+110000"},
+		{`"\uD800"`, "test.monkey:1:2: invalid unicode code point: U+D800"},
+	}
+
+	for i, tt := range tests {
+		l := NewFile("test.monkey", tt.input)
+		for {
+			tok := l.NextToken()
+			if tok.Type == token.EOF {
+				t.Fatalf("[%d] no ILLEGAL token in %q", i, tt.input)
+			}
+			if tok.Type != token.ILLEGAL {
+				continue
+			}
+			err := l.Err(tok)
+			if err == nil {
+				t.Fatalf("[%d] no error for ILLEGAL token %q", i, tok.Literal)
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("[%d] wrong error; expected = %q, got = %q", i, tt.expected, err.Error())
+			}
+			break
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := `let 名前 = "太郎";
+名前 + "さん";
+let café_au_lait = é;`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1, 1},
+		{token.IDENT, "名前", 1, 5},
+		{token.ASSIGN, "=", 1, 8},
+		{token.STRING, "太郎", 1, 10},
+		{token.SEMICOLON, ";", 1, 14},
+		{token.IDENT, "名前", 2, 1},
+		{token.PLUS, "+", 2, 4},
+		{token.STRING, "さん", 2, 6},
+		{token.SEMICOLON, ";", 2, 10},
+		{token.LET, "let", 3, 1},
+		{token.IDENT, "café_au_lait", 3, 5},
+		{token.ASSIGN, "=", 3, 18},
+		{token.IDENT, "é", 3, 20},
+		{token.SEMICOLON, ";", 3, 21},
+		{token.EOF, "", 3, 22},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("[%d] wrong type; expected = %q, got = %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("[%d] wrong literal; expected = %q, got = %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("[%d] wrong position; expected = %d:%d, got = %d:%d", i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
@@ -22,6 +22,7 @@ func run(args []string) error {
 
 func main() {
 	if err := run(os.Args); err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(command.ExitUsageError)
 	}
 }
@@ -1,8 +1,9 @@
 package object
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store   map[string]Object
+	outer   *Environment
+	exports map[string]bool
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -29,3 +30,63 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign updates the binding of name in the innermost environment that
+// defines it. It reports false if name is not defined.
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the names bound in e and its enclosing environments in no
+// particular order.
+func (e *Environment) Names() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for env := e; env != nil; env = env.outer {
+		for name := range env.store {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// Outer returns the enclosing environment, or nil for a top-level one.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Export marks the binding of name as visible to importers of the module
+// evaluated in e.
+func (e *Environment) Export(name string) {
+	if e.exports == nil {
+		e.exports = make(map[string]bool)
+	}
+	e.exports[name] = true
+}
+
+// Exported returns the value of name if it has been exported.
+func (e *Environment) Exported(name string) (Object, bool) {
+	if !e.exports[name] {
+		return nil, false
+	}
+	obj, ok := e.store[name]
+	return obj, ok
+}
+
+// ExportedNames returns the exported names in no particular order.
+func (e *Environment) ExportedNames() []string {
+	names := make([]string, 0, len(e.exports))
+	for name := range e.exports {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,213 @@
+package object
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/big"
+)
+
+// HashKey of an array combines the HashKeys of its elements in order.
+func (a *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	for _, e := range a.Elements {
+		writeHashKey(h, elementHashKey(e))
+	}
+	return HashKey{
+		Type:  a.Type(),
+		Value: h.Sum64(),
+	}
+}
+
+// HashKey of a hash combines the HashKeys of its pairs regardless of their
+// order, since hashes with the same pairs are equal.
+func (h *Hash) HashKey() HashKey {
+	var value uint64
+	for _, e := range h.entries {
+		if e.deleted {
+			continue
+		}
+		f := fnv.New64a()
+		writeHashKey(f, e.key)
+		writeHashKey(f, elementHashKey(e.pair.Value))
+		value += mix(f.Sum64())
+	}
+	return HashKey{
+		Type:  h.Type(),
+		Value: value,
+	}
+}
+
+// mix scrambles the bits of x, so that the sum of the pair hashes depends on
+// all of them and not mostly on the last bytes written.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func elementHashKey(obj Object) HashKey {
+	if h, ok := obj.(Hashable); ok {
+		return h.HashKey()
+	}
+	return HashKey{Type: obj.Type()}
+}
+
+func writeHashKey(w interface{ Write([]byte) (int, error) }, key HashKey) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], key.Value)
+	w.Write([]byte(key.Type))
+	w.Write([]byte{0})
+	w.Write(buf[:])
+}
+
+// ToHashable returns obj as a Hashable if it can be used as a hash key.
+// Arrays can be keys if all their elements can, and hashes if all their
+// values can. Values that contain themselves cannot be keys.
+func ToHashable(obj Object) (Hashable, bool) {
+	if !hashable(obj, nil) {
+		return nil, false
+	}
+	return obj.(Hashable), true
+}
+
+func hashable(obj Object, visiting map[Object]bool) bool {
+	switch obj := obj.(type) {
+	case *Array:
+		if visiting[obj] {
+			return false
+		}
+		visiting = visit(visiting, obj)
+		defer delete(visiting, obj)
+		for _, e := range obj.Elements {
+			if !hashable(e, visiting) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		if visiting[obj] {
+			return false
+		}
+		visiting = visit(visiting, obj)
+		defer delete(visiting, obj)
+		for _, e := range obj.entries {
+			if !e.deleted && !hashable(e.pair.Value, visiting) {
+				return false
+			}
+		}
+		return true
+	case Hashable:
+		return true
+	}
+	return false
+}
+
+func visit(visiting map[Object]bool, obj Object) map[Object]bool {
+	if visiting == nil {
+		visiting = make(map[Object]bool)
+	}
+	visiting[obj] = true
+	return visiting
+}
+
+// keysEqual reports whether a and b are the same hash key. It agrees with
+// HashKey: numbers are compared by value across types and all NaNs are
+// the same key.
+func keysEqual(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		if b, ok := b.(*Integer); ok {
+			return a.Value == b.Value
+		}
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !keysEqual(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		for _, e := range a.entries {
+			if e.deleted {
+				continue
+			}
+			i := b.lookup(e.key, e.pair.Key)
+			if i < 0 || !keysEqual(e.pair.Value, b.entries[i].pair.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	if an, af, ok := numberKey(a); ok {
+		bn, bf, ok := numberKey(b)
+		switch {
+		case !ok:
+			return false
+		case an != nil && bn != nil:
+			return an.Cmp(bn) == 0
+		case an == nil && bn == nil:
+			return af == bf || math.IsNaN(af) && math.IsNaN(bf)
+		}
+		return false
+	}
+	return a == b
+}
+
+// numberKey returns the value of an integral number as a big.Int, and of
+// other numbers as a float64.
+func numberKey(obj Object) (*big.Int, float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value), 0, true
+	case *BigInt:
+		return obj.Value, 0, true
+	case *Float:
+		v := obj.Value
+		if math.IsNaN(v) || math.IsInf(v, 0) || v != math.Trunc(v) {
+			return nil, v, true
+		}
+		n, _ := new(big.Float).SetFloat64(v).Int(nil)
+		return n, 0, true
+	}
+	return nil, 0, false
+}
+
+// copyKey returns a copy of array and hash keys, which are mutable, and
+// other keys as they are.
+func copyKey(obj Object) Object {
+	switch obj := obj.(type) {
+	case *Array:
+		elements := make([]Object, len(obj.Elements))
+		for i, e := range obj.Elements {
+			elements[i] = copyKey(e)
+		}
+		return &Array{Elements: elements}
+	case *Hash:
+		c := &Hash{}
+		for _, e := range obj.entries {
+			if !e.deleted {
+				c.add(e.key, HashPair{Key: e.pair.Key, Value: copyKey(e.pair.Value)})
+			}
+		}
+		return c
+	}
+	return obj
+}
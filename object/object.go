@@ -4,15 +4,22 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/lusingander/monkey/ast"
+	"github.com/lusingander/monkey/code"
+	"github.com/lusingander/monkey/token"
 )
 
 type ObjectType string
 
 const (
 	IntegerObj     = "INTEGER"
+	BigIntObj      = "BIG_INTEGER"
 	FloatObj       = "FLOAT"
 	BooleanObj     = "BOOLEAN"
 	StringObj      = "STRING"
@@ -20,11 +27,14 @@ const (
 	ArrayObj       = "ARRAY"
 	HashObj        = "HASH"
 	ReturnValueObj = "RETURN_VALUE"
+	BreakObj       = "BREAK"
+	ContinueObj    = "CONTINUE"
 	FunctionObj    = "FUNCTION"
 	BuiltinObj     = "BUILTIN"
 	ErrorObj       = "ERROR"
 	QuoteObj       = "QUOTE"
 	MacroObj       = "MACRO"
+	ModuleObj      = "MODULE"
 )
 
 type Object interface {
@@ -44,6 +54,20 @@ func (i *Integer) Inspect() string {
 	return fmt.Sprintf("%d", i.Value)
 }
 
+// BigInt is an integer outside of the range of int64. Values that fit in
+// an int64 are always represented by an Integer.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (i *BigInt) Type() ObjectType {
+	return BigIntObj
+}
+
+func (i *BigInt) Inspect() string {
+	return i.Value.String()
+}
+
 type Float struct {
 	Value float64
 }
@@ -52,8 +76,27 @@ func (i *Float) Type() ObjectType {
 	return FloatObj
 }
 
+// Inspect returns the shortest representation that parses back to the same
+// value. It always has a decimal point or an exponent, so that it does not
+// read as an Integer.
 func (i *Float) Inspect() string {
-	return fmt.Sprintf("%f", i.Value)
+	switch {
+	case math.IsNaN(i.Value):
+		return "NaN"
+	case math.IsInf(i.Value, 1):
+		return "Inf"
+	case math.IsInf(i.Value, -1):
+		return "-Inf"
+	}
+	format := byte('f')
+	if abs := math.Abs(i.Value); abs != 0 && (abs < 1e-4 || abs >= 1e21) {
+		format = 'e'
+	}
+	s := strconv.FormatFloat(i.Value, format, -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
 }
 
 type Boolean struct {
@@ -99,18 +142,54 @@ func (a *Array) Type() ObjectType {
 }
 
 func (a *Array) Inspect() string {
-	var out bytes.Buffer
-	elems := make([]string, 0)
-	for _, e := range a.Elements {
-		elems = append(elems, e.Inspect())
+	return inspect(a, nil)
+}
+
+// inspect returns obj.Inspect(). visiting holds the arrays and hashes being
+// inspected, which are shown as [...] and {...} if they contain themselves.
+func inspect(obj Object, visiting map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		if visiting[obj] {
+			return "[...]"
+		}
+		visiting = visit(visiting, obj)
+		defer delete(visiting, obj)
+
+		var out bytes.Buffer
+		elems := make([]string, 0)
+		for _, e := range obj.Elements {
+			elems = append(elems, inspect(e, visiting))
+		}
+		out.WriteString("[")
+		out.WriteString(strings.Join(elems, ", "))
+		out.WriteString("]")
+		return out.String()
+	case *Hash:
+		if visiting[obj] {
+			return "{...}"
+		}
+		visiting = visit(visiting, obj)
+		defer delete(visiting, obj)
+
+		var out bytes.Buffer
+		pairs := []string{}
+		for _, pair := range obj.Pairs() {
+			pairs = append(pairs, fmt.Sprintf("%s: %s", inspect(pair.Key, visiting), inspect(pair.Value, visiting)))
+		}
+		out.WriteString("{")
+		out.WriteString(strings.Join(pairs, ", "))
+		out.WriteString("}")
+		return out.String()
+	default:
+		return obj.Inspect()
 	}
-	out.WriteString("[")
-	out.WriteString(strings.Join(elems, ", "))
-	out.WriteString("]")
-	return out.String()
 }
 
+// Hashable objects can be used as hash keys. Arrays and hashes are
+// Hashable but can only be used as keys if ToHashable accepts them.
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
@@ -139,6 +218,39 @@ func (i *Integer) HashKey() HashKey {
 	}
 }
 
+func (i *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	if i.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(i.Value.Bytes())
+	return HashKey{
+		Type:  i.Type(),
+		Value: h.Sum64(),
+	}
+}
+
+// HashKey of a Float with an integral value is the one of the equal Integer
+// or BigInt, so that 1.0 and 1 are the same key. All NaNs share a key.
+func (f *Float) HashKey() HashKey {
+	v := f.Value
+	switch {
+	case math.IsNaN(v):
+		v = math.NaN()
+	case math.IsInf(v, 0):
+	case v == math.Trunc(v):
+		if v >= math.MinInt64 && v < math.MaxInt64 {
+			return (&Integer{Value: int64(v)}).HashKey()
+		}
+		n, _ := new(big.Float).SetFloat64(v).Int(nil)
+		return (&BigInt{Value: n}).HashKey()
+	}
+	return HashKey{
+		Type:  f.Type(),
+		Value: math.Float64bits(v),
+	}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -153,8 +265,21 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash keeps its pairs in insertion order. The zero value is an empty hash.
+//
+// Keys with the same HashKey share a bucket and are told apart by
+// comparing them. Array and hash keys are copied, so changing a value after
+// using it as a key does not change the hash.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	index   map[HashKey][]int // positions of the entries of each HashKey
+	entries []hashEntry
+	deleted int // number of deleted entries
+}
+
+type hashEntry struct {
+	key     HashKey
+	pair    HashPair
+	deleted bool
 }
 
 func (h *Hash) Type() ObjectType {
@@ -162,15 +287,112 @@ func (h *Hash) Type() ObjectType {
 }
 
 func (h *Hash) Inspect() string {
-	var out bytes.Buffer
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	return inspect(h, nil)
+}
+
+func (h *Hash) Len() int {
+	return len(h.entries) - h.deleted
+}
+
+// lookup returns the position of the entry for key, or -1.
+func (h *Hash) lookup(hashKey HashKey, key Object) int {
+	for _, i := range h.index[hashKey] {
+		if keysEqual(h.entries[i].pair.Key, key) {
+			return i
+		}
 	}
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-	return out.String()
+	return -1
+}
+
+func (h *Hash) Get(key Hashable) (HashPair, bool) {
+	i := h.lookup(key.HashKey(), key)
+	if i < 0 {
+		return HashPair{}, false
+	}
+	pair := h.entries[i].pair
+	return HashPair{Key: copyKey(pair.Key), Value: pair.Value}, true
+}
+
+// Set adds the pair at the end of the hash. If the key is already present,
+// only its value is replaced and it keeps its position.
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if i := h.lookup(hashKey, key); i >= 0 {
+		h.entries[i].pair.Value = value
+		return
+	}
+	h.add(hashKey, HashPair{Key: copyKey(key), Value: value})
+}
+
+func (h *Hash) add(hashKey HashKey, pair HashPair) {
+	if h.index == nil {
+		h.index = make(map[HashKey][]int)
+	}
+	h.index[hashKey] = append(h.index[hashKey], len(h.entries))
+	h.entries = append(h.entries, hashEntry{key: hashKey, pair: pair})
+}
+
+// Delete removes the key and reports whether it was present.
+func (h *Hash) Delete(key Hashable) bool {
+	hashKey := key.HashKey()
+	i := h.lookup(hashKey, key)
+	if i < 0 {
+		return false
+	}
+	bucket := h.index[hashKey]
+	for j, k := range bucket {
+		if k == i {
+			bucket = append(bucket[:j:j], bucket[j+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(h.index, hashKey)
+	} else {
+		h.index[hashKey] = bucket
+	}
+	h.entries[i] = hashEntry{deleted: true}
+	h.deleted++
+	if h.deleted > len(h.entries)/2 {
+		h.compact()
+	}
+	return true
+}
+
+func (h *Hash) compact() {
+	entries := h.entries
+	n := h.Len()
+	h.index = nil
+	h.entries = make([]hashEntry, 0, n)
+	h.deleted = 0
+	for _, e := range entries {
+		if !e.deleted {
+			h.add(e.key, e.pair)
+		}
+	}
+}
+
+// Pairs returns the pairs in insertion order. Array and hash keys are
+// returned as copies.
+func (h *Hash) Pairs() []HashPair {
+	pairs := make([]HashPair, 0, h.Len())
+	for _, e := range h.entries {
+		if !e.deleted {
+			pairs = append(pairs, HashPair{Key: copyKey(e.pair.Key), Value: e.pair.Value})
+		}
+	}
+	return pairs
+}
+
+// Copy returns a shallow copy of the hash.
+func (h *Hash) Copy() *Hash {
+	c := &Hash{}
+	for _, e := range h.entries {
+		if !e.deleted {
+			c.add(e.key, e.pair)
+		}
+	}
+	return c
 }
 
 type ReturnValue struct {
@@ -185,7 +407,30 @@ func (v *ReturnValue) Inspect() string {
 	return v.Value.Inspect()
 }
 
+// Break and Continue are propagated out of the statements of a loop body
+// like a ReturnValue is propagated out of a function body.
+type Break struct{}
+
+func (b *Break) Type() ObjectType {
+	return BreakObj
+}
+
+func (b *Break) Inspect() string {
+	return "break"
+}
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType {
+	return ContinueObj
+}
+
+func (c *Continue) Inspect() string {
+	return "continue"
+}
+
 type Function struct {
+	Name       string // name of the binding the function was defined by, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -210,10 +455,95 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+type CompiledFunction struct {
+	Name          string
+	Instructions  code.Instructions
+	Positions     code.SourceMap
+	NumLocals     int
+	NumParameters int
+	Captures      []Capture // where the free variables come from
+}
+
+type CaptureKind int
+
+const (
+	CaptureLocal CaptureKind = iota // a local of the enclosing function
+	CaptureFree                     // a free variable of the enclosing closure
+	CaptureSelf                     // the enclosing closure itself
+)
+
+// Capture describes how a free variable is captured when a Closure of
+// the function is created.
+type Capture struct {
+	Kind  CaptureKind
+	Index int
+}
+
+// Type reports FunctionObj: a compiled function is indistinguishable
+// from an evaluated one to Monkey programs.
+func (f *CompiledFunction) Type() ObjectType {
+	return FunctionObj
+}
+
+func (f *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", f)
+}
+
+// Closure is a CompiledFunction together with the free variables it
+// references. Unlike Function, it does not keep the whole enclosing
+// environment alive.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Upvalue
+}
+
+func (c *Closure) Type() ObjectType {
+	return FunctionObj
+}
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Upvalue is a variable captured by a Closure. While the function defining
+// the variable is running, Location points to its slot on the vm stack;
+// once the function returns, the value is moved into the Upvalue itself.
+type Upvalue struct {
+	Location *Object
+	closed   Object
+}
+
+func NewClosedUpvalue(value Object) *Upvalue {
+	u := &Upvalue{closed: value}
+	u.Location = &u.closed
+	return u
+}
+
+func (u *Upvalue) Get() Object {
+	return *u.Location
+}
+
+func (u *Upvalue) Set(value Object) {
+	*u.Location = value
+}
+
+// Close moves the value out of the stack slot into the Upvalue.
+func (u *Upvalue) Close() {
+	u.closed = *u.Location
+	u.Location = &u.closed
+}
+
 type BuiltinFunction func(args ...Object) Object
 
+// CallFunction calls a function value of the running engine.
+type CallFunction func(fn Object, args ...Object) Object
+
 type Builtin struct {
 	Fn BuiltinFunction
+
+	// CallbackFn is set instead of Fn by builtins that call functions
+	// passed as arguments, like map. The engine supplies call.
+	CallbackFn func(call CallFunction, args ...Object) Object
 }
 
 func (b *Builtin) Type() ObjectType {
@@ -226,6 +556,8 @@ func (b *Builtin) Inspect() string {
 
 type Error struct {
 	Message string
+	Pos     token.Position // where the error occurred, if known
+	Trace   []Frame        // call stack at the time of the error, outermost first
 }
 
 func (e *Error) Type() ObjectType {
@@ -233,9 +565,49 @@ func (e *Error) Type() ObjectType {
 }
 
 func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
 	return "ERROR: " + e.Message
 }
 
+// Traceback returns the call stack of the error, most recent call first.
+// Each line shows the function and the position being executed in it.
+// Consecutive identical lines, as left by a runaway recursion, are shown once.
+func (e *Error) Traceback() string {
+	if len(e.Trace) == 0 {
+		return ""
+	}
+	var out bytes.Buffer
+	var last string
+	repeated := 0
+	writeLine := func(line string) {
+		if line == last {
+			repeated++
+			return
+		}
+		if repeated > 0 {
+			out.WriteString(fmt.Sprintf("\t... repeated %d more times\n", repeated))
+			repeated = 0
+		}
+		out.WriteString(line)
+		last = line
+	}
+	pos := e.Pos
+	for i := len(e.Trace) - 1; i >= 0; i-- {
+		writeLine(fmt.Sprintf("\tat %s (%s)\n", e.Trace[i].Function, pos))
+		pos = e.Trace[i].CallSite
+	}
+	writeLine(fmt.Sprintf("\tat <main> (%s)\n", pos))
+	return out.String()
+}
+
+// Frame is an entry of the call stack.
+type Frame struct {
+	Function string
+	CallSite token.Position
+}
+
 type Quote struct {
 	Node ast.Node
 }
@@ -272,3 +644,20 @@ func (f *Macro) Inspect() string {
 	out.WriteString("}\n")
 	return out.String()
 }
+
+// Module is the result of an import expression. Its exported bindings are
+// looked up in the environment the module was evaluated in.
+type Module struct {
+	Path string
+	Env  *Environment
+}
+
+func (m *Module) Type() ObjectType {
+	return ModuleObj
+}
+
+func (m *Module) Inspect() string {
+	names := m.Env.ExportedNames()
+	sort.Strings(names)
+	return fmt.Sprintf("module(%q) {%s}", m.Path, strings.Join(names, ", "))
+}
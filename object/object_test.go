@@ -1,6 +1,12 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/lusingander/monkey/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -40,6 +46,71 @@ func TestIntegerHashKey(t *testing.T) {
 	}
 }
 
+func TestBigIntHashKey(t *testing.T) {
+	v1 := &BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 100)}
+	v2 := &BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 100)}
+	neg := &BigInt{Value: new(big.Int).Neg(v1.Value)}
+
+	if v1.HashKey() != v2.HashKey() {
+		t.Errorf("big integers with same content have diffrent hash keys")
+	}
+
+	if v1.HashKey() == neg.HashKey() {
+		t.Errorf("big integers with diffrent content have same hash keys")
+	}
+}
+
+func TestFloatHashKey(t *testing.T) {
+	tests := []struct {
+		a, b  Hashable
+		equal bool
+	}{
+		{&Float{Value: 1.5}, &Float{Value: 1.5}, true},
+		{&Float{Value: 1.5}, &Float{Value: 2.5}, false},
+		{&Float{Value: 1.0}, &Integer{Value: 1}, true},
+		{&Float{Value: -0.0}, &Integer{Value: 0}, true},
+		{&Float{Value: 1e20}, &BigInt{Value: new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)}, true},
+		{&Float{Value: math.NaN()}, &Float{Value: -math.NaN()}, true},
+		{&Float{Value: math.Inf(1)}, &Float{Value: math.Inf(-1)}, false},
+		{&Float{Value: 1.5}, &Integer{Value: 1}, false},
+	}
+
+	for _, tt := range tests {
+		if (tt.a.HashKey() == tt.b.HashKey()) != tt.equal {
+			t.Errorf("hash keys of %v and %v: want equal=%t", tt.a, tt.b, tt.equal)
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	a, b := 0.1, 0.2
+	tests := []struct {
+		input    float64
+		expected string
+	}{
+		{a + b, "0.30000000000000004"},
+		{1e-9, "1e-09"},
+		{2.5, "2.5"},
+		{3, "3.0"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+		{100, "100.0"},
+		{123456789.25, "123456789.25"},
+		{0.0001, "0.0001"},
+		{0.00001, "1e-05"},
+		{math.NaN(), "NaN"},
+		{math.Inf(1), "Inf"},
+		{math.Inf(-1), "-Inf"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.input}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect: want=%q, got=%q", tt.expected, f.Inspect())
+		}
+	}
+}
+
 func TestBooleanHashKey(t *testing.T) {
 	t1 := &Boolean{Value: true}
 	t2 := &Boolean{Value: true}
@@ -58,3 +129,233 @@ func TestBooleanHashKey(t *testing.T) {
 		t.Errorf("booleans with diffrent content have same hash keys")
 	}
 }
+
+func TestHashOrder(t *testing.T) {
+	h := &Hash{}
+	set := func(k int64, v string) {
+		key := &Integer{Value: k}
+		h.Set(key, &String{Value: v})
+	}
+	del := func(k int64) bool {
+		return h.Delete(&Integer{Value: k})
+	}
+
+	for i := int64(0); i < 10; i++ {
+		set(i, "a")
+	}
+	set(3, "b")
+	for i := int64(0); i < 8; i++ {
+		if i != 3 && !del(i) {
+			t.Fatalf("key %d not deleted", i)
+		}
+	}
+	if del(0) {
+		t.Errorf("deleted key deleted again")
+	}
+	set(0, "c")
+
+	expected := "{3: b, 8: a, 9: a, 0: c}"
+	if h.Inspect() != expected {
+		t.Errorf("wrong order: want=%q, got=%q", expected, h.Inspect())
+	}
+	if h.Len() != 4 {
+		t.Errorf("wrong length: want=4, got=%d", h.Len())
+	}
+	if pair, ok := h.Get(&Integer{Value: 9}); !ok || pair.Value.Inspect() != "a" {
+		t.Errorf("wrong pair for 9: got=%+v, %t", pair, ok)
+	}
+
+	c := h.Copy()
+	c.Delete(&Integer{Value: 3})
+	if h.Len() != 4 || c.Len() != 3 {
+		t.Errorf("copy is not independent: got len %d and %d", h.Len(), c.Len())
+	}
+}
+
+func TestCompositeHashKey(t *testing.T) {
+	array := func(elements ...Object) *Array {
+		return &Array{Elements: elements}
+	}
+	hash := func(pairs ...Hashable) *Hash {
+		h := &Hash{}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i], pairs[i+1])
+		}
+		return h
+	}
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	a, b := &String{Value: "a"}, &String{Value: "b"}
+
+	tests := []struct {
+		a, b  Hashable
+		equal bool
+	}{
+		{array(one, two), array(one, two), true},
+		{array(one, two), array(two, one), false},
+		{array(one, array(a)), array(one, array(a)), true},
+		{array(one), array(&Float{Value: 1.0}), true},
+		{array(), array(), true},
+		{array(one), one, false},
+		{hash(a, one, b, two), hash(b, two, a, one), true},
+		{hash(a, one), hash(a, two), false},
+		{hash(a, one, b, one), hash(a, two, b, two), false},
+		{hash(), array(), false},
+	}
+
+	for _, tt := range tests {
+		if (tt.a.HashKey() == tt.b.HashKey()) != tt.equal {
+			t.Errorf("hash keys of %s and %s: want equal=%t", tt.a.Inspect(), tt.b.Inspect(), tt.equal)
+		}
+		if keysEqual(tt.a, tt.b) != tt.equal {
+			t.Errorf("keys %s and %s: want equal=%t", tt.a.Inspect(), tt.b.Inspect(), tt.equal)
+		}
+	}
+}
+
+func TestToHashable(t *testing.T) {
+	self := &Array{}
+	self.Elements = []Object{self}
+	fn := &Builtin{}
+
+	tests := []struct {
+		input    Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, true},
+		{&Array{Elements: []Object{&String{Value: "a"}, &Array{}}}, true},
+		{&Array{Elements: []Object{fn}}, false},
+		{&Array{Elements: []Object{&Array{Elements: []Object{fn}}}}, false},
+		{self, false},
+		{fn, false},
+	}
+
+	for _, tt := range tests {
+		if _, ok := ToHashable(tt.input); ok != tt.expected {
+			t.Errorf("ToHashable(%s): want=%t, got=%t", tt.input.Inspect(), tt.expected, ok)
+		}
+	}
+
+	h := &Hash{}
+	h.Set(&String{Value: "f"}, fn)
+	if _, ok := ToHashable(h); ok {
+		t.Errorf("hash with a function value is hashable")
+	}
+}
+
+// collidingString has the same HashKey as every other collidingString.
+type collidingString struct {
+	String
+}
+
+func (s *collidingString) HashKey() HashKey {
+	return HashKey{Type: StringObj, Value: 42}
+}
+
+func TestHashCollisions(t *testing.T) {
+	a := &collidingString{String{Value: "a"}}
+	b := &collidingString{String{Value: "b"}}
+	c := &collidingString{String{Value: "c"}}
+
+	h := &Hash{}
+	h.Set(a, &Integer{Value: 1})
+	h.Set(b, &Integer{Value: 2})
+	h.Set(a, &Integer{Value: 3})
+
+	if h.Len() != 2 {
+		t.Fatalf("wrong length: want=2, got=%d", h.Len())
+	}
+	if pair, ok := h.Get(a); !ok || pair.Value.Inspect() != "3" {
+		t.Errorf("wrong pair for a: got=%+v, %t", pair, ok)
+	}
+	if pair, ok := h.Get(b); !ok || pair.Value.Inspect() != "2" {
+		t.Errorf("wrong pair for b: got=%+v, %t", pair, ok)
+	}
+	if _, ok := h.Get(c); ok {
+		t.Errorf("found pair for c")
+	}
+
+	if !h.Delete(a) {
+		t.Fatalf("a not deleted")
+	}
+	if _, ok := h.Get(a); ok {
+		t.Errorf("found pair for deleted a")
+	}
+	if pair, ok := h.Get(b); !ok || pair.Value.Inspect() != "2" {
+		t.Errorf("wrong pair for b after deleting a: got=%+v, %t", pair, ok)
+	}
+}
+
+func TestHashKeyIsCopied(t *testing.T) {
+	key := &Array{Elements: []Object{&Integer{Value: 1}}}
+	h := &Hash{}
+	h.Set(key, &String{Value: "v"})
+
+	key.Elements[0] = &Integer{Value: 2}
+	if _, ok := h.Get(&Array{Elements: []Object{&Integer{Value: 1}}}); !ok {
+		t.Errorf("changing the key changed the hash")
+	}
+
+	stored := h.Pairs()[0].Key.(*Array)
+	stored.Elements[0] = &Integer{Value: 3}
+	if h.Inspect() != "{[1]: v}" {
+		t.Errorf("changing a returned key changed the hash: got=%s", h.Inspect())
+	}
+}
+
+func TestSelfReferentialInspect(t *testing.T) {
+	a := &Array{}
+	a.Elements = []Object{&Integer{Value: 1}, a}
+	h := &Hash{}
+	h.Set(&String{Value: "self"}, h)
+	h.Set(&String{Value: "list"}, a)
+	shared := &Array{Elements: []Object{&Integer{Value: 1}}}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{a, "[1, [...]]"},
+		{h, "{self: {...}, list: [1, [...]]}"},
+		{&Array{Elements: []Object{shared, shared}}, "[[1], [1]]"},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.obj.Inspect(); actual != tt.expected {
+			t.Errorf("wrong Inspect: want=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	pos := func(line, column int) token.Position {
+		return token.Position{Filename: "test.monkey", Line: line, Column: column}
+	}
+	recursion := []Frame{{Function: "g", CallSite: pos(5, 1)}}
+	for i := 0; i < 4; i++ {
+		recursion = append(recursion, Frame{Function: "f", CallSite: pos(2, 3)})
+	}
+
+	tests := []struct {
+		err      *Error
+		expected string
+	}{
+		{&Error{Pos: pos(1, 1)}, ""},
+		{
+			&Error{Pos: pos(3, 5), Trace: []Frame{{Function: "f", CallSite: pos(4, 1)}}},
+			"\tat f (test.monkey:3:5)\n\tat <main> (test.monkey:4:1)\n",
+		},
+		{
+			&Error{Pos: pos(2, 3), Trace: recursion},
+			"\tat f (test.monkey:2:3)\n" +
+				"\t... repeated 3 more times\n" +
+				"\tat g (test.monkey:2:3)\n" +
+				"\tat <main> (test.monkey:5:1)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.err.Traceback(); actual != tt.expected {
+			t.Errorf("wrong traceback: want=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
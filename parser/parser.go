@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
 
 	"github.com/lusingander/monkey/ast"
@@ -17,28 +18,51 @@ type (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	LOGICALOR   // ||
+	LOGICALAND  // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
+	BITOR       // |
+	BITXOR      // ^
+	BITAND      // &
+	SHIFT       // << or >>
 	SUM         // +
-	PRODUCT     // *
+	PRODUCT     // * or %
 	PREFIX      // -X or !X
+	POWER       // **
 	CALL        // func(X)
 	INDEX       // array[index]
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOTEQ:    EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.LE:       LESSGREATER,
-	token.GE:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.OR:              LOGICALOR,
+	token.AND:             LOGICALAND,
+	token.EQ:              EQUALS,
+	token.NOTEQ:           EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LE:              LESSGREATER,
+	token.GE:              LESSGREATER,
+	token.PIPE:            BITOR,
+	token.CARET:           BITXOR,
+	token.AMPERSAND:       BITAND,
+	token.LSHIFT:          SHIFT,
+	token.RSHIFT:          SHIFT,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.POWER:           POWER,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+	token.DOT:             INDEX,
 }
 
 type Parser struct {
@@ -50,6 +74,14 @@ type Parser struct {
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 
+	// loopDepth is the number of loops enclosing the current token within
+	// the current function, to reject break and continue outside of loops.
+	loopDepth int
+
+	// loopJumps are the break and continue statements parsed so far in the
+	// innermost loop, to reject those inside an expression.
+	loopJumps []token.Token
+
 	errors []string
 }
 
@@ -65,21 +97,39 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.STRING_HEAD, p.parseInterpolatedString)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.LSHIFT, p.parseInfixExpression)
+	p.registerInfix(token.RSHIFT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOTEQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
@@ -88,6 +138,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.GE, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseDotExpression)
 
 	// set curToken and peekToken
 	p.NextToken()
@@ -101,7 +152,11 @@ func (p *Parser) Errors() []string {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
+	p.errorf(p.peekToken.Pos, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
+}
+
+func (p *Parser) errorf(pos token.Position, format string, a ...interface{}) {
+	msg := pos.String() + ": " + fmt.Sprintf(format, a...)
 	p.errors = append(p.errors, msg)
 }
 
@@ -131,6 +186,16 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -156,7 +221,179 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	p.NextToken()
 
+	jumps := len(p.loopJumps)
 	stmt.Value = p.parseExpression(LOWEST)
+	p.rejectLoopJumps(jumps)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseExportStatement() *ast.ExportStatement {
+	stmt := &ast.ExportStatement{
+		Token: p.curToken,
+	}
+
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+
+	stmt.Statement = p.parseLetStatement()
+	if stmt.Statement == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{
+		Token: p.curToken,
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.NextToken()
+	jumps := len(p.loopJumps)
+	stmt.Condition = p.parseExpression(LOWEST)
+	p.rejectLoopJumps(jumps)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+
+	return stmt
+}
+
+// parseForStatement parses both for (init; condition; post) { ... } and
+// for (x in iterable) { ... }.
+func (p *Parser) parseForStatement() ast.Statement {
+	tok := p.curToken
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.NextToken()
+	if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.IN) {
+		return p.parseForInStatement(tok)
+	}
+
+	stmt := &ast.ForStatement{
+		Token: tok,
+	}
+
+	if !p.curTokenIs(token.SEMICOLON) {
+		stmt.Init = p.parseStatement()
+		if !p.curTokenIs(token.SEMICOLON) && !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	p.NextToken()
+	if !p.curTokenIs(token.SEMICOLON) {
+		jumps := len(p.loopJumps)
+		stmt.Condition = p.parseExpression(LOWEST)
+		p.rejectLoopJumps(jumps)
+		if !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	p.NextToken()
+	if !p.curTokenIs(token.RPAREN) {
+		stmt.Post = p.parseStatement()
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForInStatement(tok token.Token) ast.Statement {
+	stmt := &ast.ForInStatement{
+		Token: tok,
+		Variable: &ast.Identifier{
+			Token: p.curToken,
+			Value: p.curToken.Literal,
+		},
+	}
+
+	p.NextToken()
+	p.NextToken()
+	jumps := len(p.loopJumps)
+	stmt.Iterable = p.parseExpression(LOWEST)
+	p.rejectLoopJumps(jumps)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{
+		Token: p.curToken,
+	}
+	if p.loopDepth == 0 {
+		p.errorf(p.curToken.Pos, "break outside of loop")
+	} else {
+		p.loopJumps = append(p.loopJumps, p.curToken)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.NextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{
+		Token: p.curToken,
+	}
+	if p.loopDepth == 0 {
+		p.errorf(p.curToken.Pos, "continue outside of loop")
+	} else {
+		p.loopJumps = append(p.loopJumps, p.curToken)
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.NextToken()
@@ -172,7 +409,9 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	p.NextToken()
 
+	jumps := len(p.loopJumps)
 	stmt.ReturnValue = p.parseExpression(LOWEST)
+	p.rejectLoopJumps(jumps)
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.NextToken()
@@ -186,7 +425,13 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 		Token: p.curToken,
 	}
 
+	jumps := len(p.loopJumps)
 	stmt.Expression = p.parseExpression(LOWEST)
+	// an if expression as a whole statement is the only way to break out
+	// of a loop conditionally
+	if _, ok := stmt.Expression.(*ast.IfExpression); !ok {
+		p.rejectLoopJumps(jumps)
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.NextToken()
@@ -195,6 +440,16 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	return stmt
 }
 
+// rejectLoopJumps reports the break and continue statements parsed after
+// the first n ones of the loop. They are inside an expression, whose
+// evaluation they would leave unfinished.
+func (p *Parser) rejectLoopJumps(n int) {
+	for _, tok := range p.loopJumps[n:] {
+		p.errorf(tok.Pos, "%s inside an expression", tok.Literal)
+	}
+	p.loopJumps = p.loopJumps[:n]
+}
+
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
@@ -216,8 +471,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorf(p.curToken.Pos, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
@@ -232,13 +486,16 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 		Token: p.curToken,
 	}
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
-	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+	if err == nil {
+		lit.Value = value
+		return lit
 	}
-	lit.Value = value
-	return lit
+	if n, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+		lit.Big = n
+		return lit
+	}
+	p.errorf(p.curToken.Pos, "could not parse %q as integer", p.curToken.Literal)
+	return nil
 }
 
 func (p *Parser) parseFloatLiteral() ast.Expression {
@@ -247,8 +504,7 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	}
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken.Pos, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 	lit.Value = value
@@ -269,6 +525,38 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+	for {
+		str.Parts = append(str.Parts, p.parseStringLiteral())
+		if p.curTokenIs(token.STRING_TAIL) {
+			str.Close = p.curToken.End
+			return str
+		}
+		p.NextToken()
+		if p.curTokenIs(token.STRING_MIDDLE) || p.curTokenIs(token.STRING_TAIL) {
+			p.errorf(p.curToken.Pos, "empty interpolation")
+			return nil
+		}
+		str.Parts = append(str.Parts, p.parseExpression(LOWEST))
+		if !p.peekTokenIs(token.STRING_MIDDLE) && !p.peekTokenIs(token.STRING_TAIL) {
+			p.peekError(token.RBRACE)
+			return nil
+		}
+		p.NextToken()
+	}
+}
+
+// parseIllegal reports the reason the lexer gave for an ILLEGAL token.
+func (p *Parser) parseIllegal() ast.Expression {
+	if err := p.l.Err(p.curToken); err != nil {
+		p.errors = append(p.errors, err.Error())
+	} else {
+		p.noPrefixParseFnError(p.curToken.Type)
+	}
+	return nil
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	expression := &ast.PrefixExpression{
 		Token:    p.curToken,
@@ -290,12 +578,39 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.curPrecedence()
+	if expression.Operator == "**" {
+		// right-associative: 2 ** 3 ** 2 is 2 ** (3 ** 2)
+		precedence--
+	}
 	p.NextToken()
 	expression.Right = p.parseExpression(precedence)
 
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case nil:
+		return nil
+	default:
+		p.errorf(p.curToken.Pos, "cannot assign to %s", target.String())
+		return nil
+	}
+
+	// assignment is right-associative: a = b = c is a = (b = c)
+	p.NextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.NextToken()
 
@@ -317,7 +632,9 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	}
 
 	p.NextToken()
+	jumps := len(p.loopJumps)
 	expression.Condition = p.parseExpression(LOWEST)
+	p.rejectLoopJumps(jumps)
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
@@ -357,6 +674,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.NextToken()
 	}
+	block.Rbrace = p.curToken.End
 
 	return block
 }
@@ -376,11 +694,31 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Body = p.parseBlockStatement()
+	lit.Body = p.parseFunctionBody()
 
 	return lit
 }
 
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	jumps := p.loopJumps
+	p.loopDepth++
+	p.loopJumps = nil
+	defer func() {
+		p.loopDepth--
+		p.loopJumps = jumps
+	}()
+	return p.parseBlockStatement()
+}
+
+// parseFunctionBody parses the body of a function or macro, which is not
+// part of the loops around the literal.
+func (p *Parser) parseFunctionBody() *ast.BlockStatement {
+	depth, jumps := p.loopDepth, p.loopJumps
+	p.loopDepth, p.loopJumps = 0, nil
+	defer func() { p.loopDepth, p.loopJumps = depth, jumps }()
+	return p.parseBlockStatement()
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	identifiers := make([]*ast.Identifier, 0)
 
@@ -420,6 +758,7 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 		Function: function,
 	}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken.End
 	return exp
 }
 
@@ -452,21 +791,88 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 		Token: p.curToken,
 	}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken.End
 	return array
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.NextToken()
+		index = p.parseExpression(LOWEST)
+	}
+	if p.peekTokenIs(token.COLON) {
+		p.NextToken()
+		return p.parseSliceExpression(tok, left, index)
+	}
 	exp := &ast.IndexExpression{
-		Token: p.curToken,
+		Token: tok,
 		Left:  left,
+		Index: index,
 	}
 
-	p.NextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	exp.Rbracket = p.curToken.End
+
+	return exp
+}
+
+// parseSliceExpression parses the rest of left[low:high] with the current
+// token at the colon. low is nil when omitted.
+func (p *Parser) parseSliceExpression(tok token.Token, left, low ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{
+		Token: tok,
+		Left:  left,
+		Low:   low,
+	}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.NextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.Rbracket = p.curToken.End
+
+	return exp
+}
+
+func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{
+		Token: p.curToken,
+		Left:  left,
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Index = &ast.StringLiteral{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	}
+	exp.Rbracket = p.curToken.End
+
+	return exp
+}
+
+func (p *Parser) parseImportExpression() ast.Expression {
+	exp := &ast.ImportExpression{
+		Token: p.curToken,
+	}
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	exp.Path = &ast.StringLiteral{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	}
 
 	return exp
 }
@@ -489,6 +895,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -498,6 +905,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken.End
 
 	return hash
 }
@@ -517,7 +925,7 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Body = p.parseBlockStatement()
+	lit.Body = p.parseFunctionBody()
 
 	return lit
 }
@@ -145,6 +145,69 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestNumberLiteralValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1_000_000", 1000000},
+		{"0x1F", 31},
+		{"0b1010", 10},
+		{"0o755", 493},
+		{".5", 0.5},
+		{"1e3", 1000.0},
+		{"2.5e-3", 0.0025},
+		{"1_000.5", 1000.5},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		switch expected := tt.expected.(type) {
+		case int:
+			integ, ok := stmt.Expression.(*ast.IntegerLiteral)
+			if !ok {
+				t.Errorf("exp not *ast.IntegerLiteral: got=%T", stmt.Expression)
+				continue
+			}
+			if integ.Value != int64(expected) {
+				t.Errorf("%s: wrong value: want=%d, got=%d", tt.input, expected, integ.Value)
+			}
+		case float64:
+			float, ok := stmt.Expression.(*ast.FloatLiteral)
+			if !ok {
+				t.Errorf("exp not *ast.FloatLiteral: got=%T", stmt.Expression)
+				continue
+			}
+			if float.Value != expected {
+				t.Errorf("%s: wrong value: want=%g, got=%g", tt.input, expected, float.Value)
+			}
+		}
+	}
+}
+
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	input := "123456789012345678901234567890;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.IntegerLiteral: got=%T", stmt.Expression)
+	}
+	if literal.Big == nil || literal.Big.String() != "123456789012345678901234567890" {
+		t.Errorf("literal.Big not %s: got=%v", "123456789012345678901234567890", literal.Big)
+	}
+}
+
 func TestFloatLiteralExpression(t *testing.T) {
 	input := "12.345;"
 
@@ -227,6 +290,42 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input    string
+		parts    int
+		expected string
+	}{
+		{`"Hello ${name}!"`, 3, "Hello ${name}!"},
+		{`"${a}${b + 1}"`, 5, "${a}${(b + 1)}"},
+		{`"x = ${"${x}"}"`, 3, "x = ${${x}}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("stmt.Expression not *ast.InterpolatedString: got=%T", stmt.Expression)
+		}
+		if len(str.Parts) != tt.parts {
+			t.Errorf("wrong number of parts: want=%d, got=%d", tt.parts, len(str.Parts))
+		}
+		for i := 0; i < len(str.Parts); i += 2 {
+			if _, ok := str.Parts[i].(*ast.StringLiteral); !ok {
+				t.Errorf("str.Parts[%d] not *ast.StringLiteral: got=%T", i, str.Parts[i])
+			}
+		}
+		if str.String() != tt.expected {
+			t.Errorf("str.String() wrong: want=%q, got=%q", tt.expected, str.String())
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -431,6 +530,54 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a % b * c",
+			"((a % b) * c)",
+		},
+		{
+			"a ** b ** c",
+			"(a ** (b ** c))",
+		},
+		{
+			"-a ** b",
+			"(-(a ** b))",
+		},
+		{
+			"a * b ** c",
+			"(a * (b ** c))",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || c",
+			"((a && b) || c)",
+		},
+		{
+			"a | b ^ c & d",
+			"(a | (b ^ (c & d)))",
+		},
+		{
+			"a << b + c < d >> e",
+			"((a << (b + c)) < (d >> e))",
+		},
+		{
+			"a == b & c",
+			"(a == (b & c))",
+		},
+		{
+			"~a & ~b",
+			"((~a) & (~b))",
+		},
+		{
+			"a[1:b + 1][-1]",
+			"((a[1:(b + 1)])[(-1)])",
+		},
+		{
+			"s[:n] + s[n:]",
+			"((s[:n]) + (s[n:]))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -773,6 +920,76 @@ func TestIndexExpressionsParsing(t *testing.T) {
 	testInfixExpression(t, exp.Index, 1, "+", 1)
 }
 
+func TestSliceExpressionsParsing(t *testing.T) {
+	tests := []struct {
+		input string
+		low   interface{}
+		high  interface{}
+	}{
+		{"myArray[1:3]", 1, 3},
+		{"myArray[:3]", nil, 3},
+		{"myArray[1:]", 1, nil},
+		{"myArray[:]", nil, nil},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.SliceExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not *ast.SliceExpression: got=%T", stmt.Expression)
+		}
+
+		testIdentifier(t, exp.Left, "myArray")
+		for _, bound := range []struct {
+			exp      ast.Expression
+			expected interface{}
+		}{{exp.Low, tt.low}, {exp.High, tt.high}} {
+			if bound.expected == nil {
+				if bound.exp != nil {
+					t.Errorf("%s: omitted bound is not nil: got=%s", tt.input, bound.exp)
+				}
+				continue
+			}
+			testLiteralExpression(t, bound.exp, bound.expected)
+		}
+	}
+}
+
+func TestDotExpressionsParsing(t *testing.T) {
+	input := `lib.add(1, 2)`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	call, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not *ast.CallExpression: got=%T", stmt.Expression)
+	}
+	exp, ok := call.Function.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("call.Function is not *ast.IndexExpression: got=%T", call.Function)
+	}
+
+	testIdentifier(t, exp.Left, "lib")
+	index, ok := exp.Index.(*ast.StringLiteral)
+	if !ok || index.Value != "add" {
+		t.Errorf("exp.Index is not \"add\": got=%T (%+v)", exp.Index, exp.Index)
+	}
+	if exp.String() != "(lib.add)" {
+		t.Errorf("exp.String() wrong: got=%q", exp.String())
+	}
+}
+
 func TestHashLiteralsStringKeysParsing(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 
@@ -877,6 +1094,37 @@ func TestHashLiteralsIntegerKeysParsing(t *testing.T) {
 	}
 }
 
+func TestHashLiteralsKeyOrder(t *testing.T) {
+	input := `{"c": 1, "a": 2, "b": 3}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral: got=%T", stmt.Expression)
+	}
+
+	expected := []string{"c", "a", "b"}
+	if len(hash.Keys) != len(expected) {
+		t.Fatalf("hash.Keys has wrong length: want=%d, got=%d", len(expected), len(hash.Keys))
+	}
+	for i, key := range hash.Keys {
+		if key.String() != expected[i] {
+			t.Errorf("hash.Keys[%d] wrong: want=%q, got=%q", i, expected[i], key.String())
+		}
+		if _, ok := hash.Pairs[key]; !ok {
+			t.Errorf("hash.Keys[%d] not in hash.Pairs", i)
+		}
+	}
+	if hash.String() != "{c:1, a:2, b:3}" {
+		t.Errorf("hash.String() wrong: got=%q", hash.String())
+	}
+}
+
 func TestEmptyHashLiteralsParsing(t *testing.T) {
 	input := `{}`
 
@@ -985,6 +1233,291 @@ func TestMacroLiteralExpression(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestImportExpression(t *testing.T) {
+	input := `let lib = import "lib/math.monkey";`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	exp, ok := stmt.Value.(*ast.ImportExpression)
+	if !ok {
+		t.Fatalf("stmt.Value is not *ast.ImportExpression: got=%T", stmt.Value)
+	}
+	if exp.Path.Value != "lib/math.monkey" {
+		t.Errorf("exp.Path.Value wrong: want=%q, got=%q", "lib/math.monkey", exp.Path.Value)
+	}
+}
+
+func TestExportStatement(t *testing.T) {
+	input := `export let x = 5;`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements doen not contain 1 statements: got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.ExportStatement: got=%T", program.Statements[0])
+	}
+	if !testLetStatement(t, stmt.Statement, "x") {
+		return
+	}
+	testLiteralExpression(t, stmt.Statement.Value, 5)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5", "(x = 5)"},
+		{"x = y = 1 + 2", "(x = (y = (1 + 2)))"},
+		{"x += 2 * 3", "(x += (2 * 3))"},
+		{"x -= 1", "(x -= 1)"},
+		{"x *= y == 1", "(x *= (y == 1))"},
+		{"x /= 2", "(x /= 2)"},
+		{"a[1] = b[2]", "((a[1]) = (b[2]))"},
+		{`h["k"] += 1`, `((h[k]) += 1)`},
+		{"m.x = 1", "((m.x) = 1)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.AssignExpression); !ok {
+			t.Errorf("stmt.Expression is not *ast.AssignExpression: got=%T", stmt.Expression)
+			continue
+		}
+		if program.String() != tt.expected {
+			t.Errorf("wrong string: want=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; }`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements doen not contain 1 statements: got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.WhileStatement: got=%T", program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements: got=%d", len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("stmt.Body.Statements[1] is not *ast.BreakStatement: got=%T", stmt.Body.Statements[1])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for (let i = 0; i < 10; let i = i + 1) { continue; }", "for (let i = 0; (i < 10); let i = (i + 1)) continue;"},
+		{"for (;;) { break; }", "for (; ; ) break;"},
+		{"for (f(); ; ) { }", "for (f(); ; ) "},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements doen not contain 1 statements: got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not *ast.ForStatement: got=%T", program.Statements[0])
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("wrong string: want=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestForInStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { puts(x); }`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ForInStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.ForInStatement: got=%T", program.Statements[0])
+	}
+	testIdentifier(t, stmt.Variable, "x")
+	if _, ok := stmt.Iterable.(*ast.ArrayLiteral); !ok {
+		t.Errorf("stmt.Iterable is not *ast.ArrayLiteral: got=%T", stmt.Iterable)
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statements: got=%d", len(stmt.Body.Statements))
+	}
+}
+
+func TestLoopStatementSemicolon(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (false) { }; puts(1);", "whilefalse puts(1)"},
+		{"for (;;) { break; }; puts(1);", "for (; ; ) break;puts(1)"},
+		{"for (x in []) { }; puts(1);", "for (x in []) puts(1)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 2 {
+			t.Fatalf("program.Statements does not contain 2 statements: got=%d", len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("wrong string: want=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestLoopJumpStatements(t *testing.T) {
+	tests := []string{
+		"while (true) { if (a) { if (b) { break; } } else { continue; } }",
+		"while (true) { (if (a) { break }) }",
+		"while (true) { puts(if (a) { while (true) { break; } 1 }); }",
+		"for (x in [1]) { let f = fn() { for (y in [2]) { if (y) { continue; } } }; }",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+		checkParserErrors(t, p)
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(x, y) {
+  x + y;
+};
+add(1, [2, 3][0]);`
+
+	l := lexer.NewFile("test.monkey", input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program has not enough statements: got=%d", len(program.Statements))
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FunctionLiteral)
+	infix := fn.Body.Statements[0].(*ast.ExpressionStatement).Expression
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1]
+
+	tests := []struct {
+		node     ast.Node
+		expected string
+		end      string
+	}{
+		{program, "test.monkey:1:1", "test.monkey:4:18"},
+		{let, "test.monkey:1:1", "test.monkey:3:2"},
+		{fn, "test.monkey:1:11", "test.monkey:3:2"},
+		{fn.Body, "test.monkey:1:20", "test.monkey:3:2"},
+		{infix, "test.monkey:2:3", "test.monkey:2:8"},
+		{call, "test.monkey:4:1", "test.monkey:4:18"},
+		{index, "test.monkey:4:8", "test.monkey:4:17"},
+	}
+
+	for _, tt := range tests {
+		if tt.node.Pos().String() != tt.expected {
+			t.Errorf("wrong position of %q: want=%s, got=%s", tt.node.String(), tt.expected, tt.node.Pos())
+		}
+		if tt.node.End().String() != tt.end {
+			t.Errorf("wrong end position of %q: want=%s, got=%s", tt.node.String(), tt.end, tt.node.End())
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;", "test.monkey:1:5: expected next token to be IDENT, got = instead"},
+		{"let x = 1;\n  ;", "test.monkey:2:3: no prefix parse function for ; found"},
+		{"1.2.3", "test.monkey:1:1: no prefix parse function for ILLEGAL found"},
+		{"0x", `test.monkey:1:1: could not parse "0x" as integer`},
+		{"1e", `test.monkey:1:1: could not parse "1e" as float`},
+		{"12abc", `test.monkey:1:1: could not parse "12abc" as integer`},
+		{"while (true) { fn() { break; } }", "test.monkey:1:23: break outside of loop"},
+		{"continue;", "test.monkey:1:1: continue outside of loop"},
+		{"while (true) { puts(if (true) { continue }) }", "test.monkey:1:33: continue inside an expression"},
+		{"while (true) { let x = if (true) { break } else { 1 }; }", "test.monkey:1:36: break inside an expression"},
+		{"while (true) { if (true) { break } + 1; }", "test.monkey:1:28: break inside an expression"},
+		{"while (true) { while (if (true) { break } else { true }) { } }", "test.monkey:1:35: break inside an expression"},
+		{"1 = 2;", "test.monkey:1:3: cannot assign to 1"},
+		{"f() += 2;", "test.monkey:1:5: cannot assign to f()"},
+		{"for (let i = 0; i < 3 { }", "test.monkey:1:23: expected next token to be ;, got { instead"},
+		{`let s = "abc;`, "test.monkey:1:9: unterminated string literal"},
+		{`"a\qb";`, `test.monkey:1:3: unknown escape sequence: \q`},
+		{`"${}";`, "test.monkey:1:4: empty interpolation"},
+		{`"${a b}";`, "test.monkey:1:6: expected next token to be }, got IDENT instead"},
+		{"a[1:2:3]", "test.monkey:1:6: expected next token to be ], got : instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewFile("test.monkey", tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("no parser errors: input=%q", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error message: want=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
 // test helper functions
 
 func testLetStatement(t *testing.T, stmt ast.Statement, name string) bool {
@@ -0,0 +1,155 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/lusingander/monkey/evaluator"
+	"github.com/lusingander/monkey/lexer"
+	"github.com/lusingander/monkey/object"
+	"github.com/lusingander/monkey/token"
+)
+
+const help = `:help          show this help
+:tokens EXPR   show the tokens of EXPR
+:ast EXPR      show the syntax tree of EXPR
+:expand EXPR   show EXPR after macro expansion
+:type EXPR     evaluate EXPR and show the type of the result
+:env           list the bindings and macros of the session
+:load FILE     evaluate FILE in the session
+:reset         clear the bindings and macros of the session
+`
+
+// isCommand reports whether input is a meta-command rather than code.
+func isCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), ":")
+}
+
+// command runs the meta-command in line.
+func (s *session) command(line string) {
+	line = strings.TrimSpace(line)
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch name {
+	case ":help":
+		io.WriteString(s.out, help)
+	case ":tokens":
+		if s.checkArg(name, "EXPR", arg) {
+			s.printTokens(arg)
+		}
+	case ":ast":
+		if !s.checkArg(name, "EXPR", arg) {
+			return
+		}
+		if program, ok := s.parse("", arg); ok {
+			printTree(s.out, program)
+		}
+	case ":expand":
+		if s.checkArg(name, "EXPR", arg) {
+			s.expand(arg)
+		}
+	case ":type":
+		if s.checkArg(name, "EXPR", arg) {
+			s.printType(arg)
+		}
+	case ":env":
+		s.printBindings("bindings", s.env)
+		s.printBindings("macros", s.macroEnv)
+	case ":load":
+		if !s.checkArg(name, "FILE", arg) {
+			return
+		}
+		content, err := ioutil.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintf(s.out, "cannot load: %s\n", err)
+			return
+		}
+		defer evaluator.EnterFile(arg)()
+		s.evalSource(arg, string(content))
+	case ":reset":
+		s.reset()
+	default:
+		fmt.Fprintf(s.out, "unknown command: %s (try :help)\n", name)
+	}
+}
+
+func (s *session) checkArg(name, param, arg string) bool {
+	if arg == "" {
+		fmt.Fprintf(s.out, "usage: %s %s\n", name, param)
+		return false
+	}
+	return true
+}
+
+func (s *session) printTokens(input string) {
+	l := lexer.New(input)
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			return
+		}
+		fmt.Fprintf(s.out, "%s\t%s\t%q", tok.Pos, tok.Type, tok.Literal)
+		if err := l.Err(tok); err != nil {
+			fmt.Fprintf(s.out, "\t%s", err.Msg)
+		}
+		io.WriteString(s.out, "\n")
+	}
+}
+
+// expand shows input after macro expansion. Macros it defines are visible
+// only to itself.
+func (s *session) expand(input string) {
+	program, ok := s.parse("", input)
+	if !ok {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(evaluator.Exit); ok {
+				panic(r)
+			}
+			io.WriteString(s.out, s.printer.format(evaluator.PanicError(r))+"\n")
+		}
+	}()
+
+	macroEnv := object.NewEnclosedEnvironment(s.macroEnv)
+	evaluator.DefineMacros(program, macroEnv)
+	expanded := evaluator.ExpandMacros(program, macroEnv)
+	io.WriteString(s.out, expanded.String()+"\n")
+}
+
+func (s *session) printType(input string) {
+	program, ok := s.parse("", input)
+	if !ok {
+		return
+	}
+	evaluated := eval(program, s.env, s.macroEnv)
+	switch evaluated := evaluated.(type) {
+	case nil:
+		io.WriteString(s.out, "no value\n")
+	case *object.Error:
+		io.WriteString(s.out, s.printer.format(evaluated)+"\n")
+		io.WriteString(s.out, evaluated.Traceback())
+	default:
+		io.WriteString(s.out, string(evaluated.Type())+"\n")
+	}
+}
+
+func (s *session) printBindings(title string, env *object.Environment) {
+	names := env.Names()
+	sort.Strings(names)
+	fmt.Fprintf(s.out, "%s:\n", title)
+	if len(names) == 0 {
+		io.WriteString(s.out, "  (none)\n")
+	}
+	for _, name := range names {
+		value, _ := env.Get(name)
+		fmt.Fprintf(s.out, "  %s = %s\n", name, s.printer.value(value, 2, nil))
+	}
+}
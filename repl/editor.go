@@ -0,0 +1,91 @@
+package repl
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lusingander/monkey/evaluator"
+	"github.com/lusingander/monkey/object"
+	"github.com/lusingander/monkey/token"
+	"github.com/peterh/liner"
+)
+
+// editor reads lines from the terminal with line editing, history search
+// with Ctrl-R and tab completion. Ctrl-C abandons the current entry.
+type editor struct {
+	line        *liner.State
+	historyFile string
+}
+
+func newEditor(historyFile string, s *session) *editor {
+	line := liner.NewLiner()
+	line.SetCtrlCAborts(true)
+	line.SetTabCompletionStyle(liner.TabPrints)
+	line.SetWordCompleter(func(line string, pos int) (string, []string, string) {
+		return complete(line, pos, s.env)
+	})
+	if f, err := os.Open(historyFile); err == nil {
+		line.ReadHistory(f)
+		f.Close()
+	}
+	return &editor{line: line, historyFile: historyFile}
+}
+
+func (e *editor) readLine(prompt string) (string, error) {
+	s, err := e.line.Prompt(prompt)
+	if err == liner.ErrPromptAborted {
+		return "", errAborted
+	}
+	if err == nil && strings.TrimSpace(s) != "" {
+		e.line.AppendHistory(s)
+	}
+	return s, err
+}
+
+// close saves the history and restores the terminal.
+func (e *editor) close() error {
+	if e.historyFile != "" {
+		if f, err := os.Create(e.historyFile); err == nil {
+			e.line.WriteHistory(f)
+			f.Close()
+		}
+	}
+	return e.line.Close()
+}
+
+// complete returns the candidates for the identifier that ends at the
+// cursor position pos, counted in characters, along with the text of line
+// before and after it. Candidates are the keywords, the builtin functions
+// and the names bound in env.
+func complete(line string, pos int, env *object.Environment) (string, []string, string) {
+	runes := []rune(line)
+	start := pos
+	for start > 0 && isLetter(runes[start-1]) {
+		start--
+	}
+	head, prefix, tail := string(runes[:start]), string(runes[start:pos]), string(runes[pos:])
+	if prefix == "" {
+		return head, nil, tail
+	}
+
+	seen := make(map[string]bool)
+	var candidates []string
+	for _, names := range [][]string{token.Keywords(), evaluator.BuiltinNames(), env.Names()} {
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) && !seen[name] {
+				seen[name] = true
+				candidates = append(candidates, name)
+			}
+		}
+	}
+	sort.Strings(candidates)
+	return head, candidates, tail
+}
+
+// isLetter reports whether r may appear in an identifier, like the
+// lexer's isLetter.
+func isLetter(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
@@ -0,0 +1,105 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lusingander/monkey/lexer"
+	"github.com/lusingander/monkey/token"
+)
+
+const continuationPrompt = ".. "
+
+// errAborted is returned by a lineReader when the user abandons the entry.
+var errAborted = errors.New("input aborted")
+
+type lineReader interface {
+	// readLine shows prompt and reads a line. It returns io.EOF at the end
+	// of the input.
+	readLine(prompt string) (string, error)
+}
+
+// scannerReader reads lines from a non-interactive input.
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scannerReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// readInput reads lines until they form a complete entry, showing the
+// continuation prompt for every line after the first. A meta-command is
+// always a single line. Two blank lines in a row or an abort abandon a
+// partial entry. It returns false at the end of the input when no lines
+// are pending.
+func readInput(r lineReader, out io.Writer) (string, bool) {
+	var lines []string
+	for {
+		p := prompt
+		if len(lines) > 0 {
+			p = continuationPrompt
+		}
+		line, err := r.readLine(p)
+		if err == errAborted {
+			lines = nil
+			continue
+		}
+		if err != nil {
+			if len(lines) > 0 {
+				// let the parser report what is missing
+				return strings.Join(lines, "\n"), true
+			}
+			return "", false
+		}
+		if len(lines) > 0 && line == "" && lines[len(lines)-1] == "" {
+			io.WriteString(out, "(input discarded)\n")
+			lines = nil
+			continue
+		}
+		lines = append(lines, line)
+		input := strings.Join(lines, "\n")
+		if isCommand(input) || !incomplete(input) {
+			return input, true
+		}
+	}
+}
+
+// incomplete reports whether more lines could complete input: it has
+// unclosed parentheses, braces, brackets or interpolations, or an
+// unterminated raw string. Double-quoted strings cannot span lines, so an
+// unterminated one is left for the parser to report.
+func incomplete(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+	for {
+		tok := l.NextToken()
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET, token.STRING_HEAD:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET, token.STRING_TAIL:
+			depth--
+			if depth < 0 {
+				return false
+			}
+		case token.ILLEGAL:
+			// a raw string is only illegal if it is unterminated
+			if strings.HasPrefix(tok.Literal, "`") {
+				return true
+			}
+		case token.EOF:
+			return depth > 0
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package repl
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lusingander/monkey/object"
+)
+
+const (
+	// printWidth is the width up to which arrays and hashes are printed
+	// on a single line.
+	printWidth = 80
+	// maxItems is the number of elements of an array or pairs of a hash
+	// printed before the rest are left out.
+	maxItems = 100
+)
+
+// ANSI escape sequences for the colors of the values by type.
+const (
+	colorReset  = "\x1b[0m"
+	colorNumber = "\x1b[33m"
+	colorString = "\x1b[32m"
+	colorBool   = "\x1b[35m"
+	colorNull   = "\x1b[90m"
+	colorError  = "\x1b[1;31m"
+	colorOther  = "\x1b[36m"
+)
+
+// printer formats the results shown by the REPL. Strings are quoted, and
+// arrays and hashes that do not fit on a line are spread over several
+// lines.
+type printer struct {
+	color bool
+}
+
+func (p *printer) format(obj object.Object) string {
+	return p.value(obj, 0, nil)
+}
+
+// value formats obj for a line indented by indent spaces. visiting holds
+// the arrays and hashes being formatted, which are printed as [...] and
+// {...} if they contain themselves.
+func (p *printer) value(obj object.Object, indent int, visiting map[object.Object]bool) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return p.paint(colorString, quote(obj.Value))
+	case *object.Integer, *object.BigInt, *object.Float:
+		return p.paint(colorNumber, obj.Inspect())
+	case *object.Boolean:
+		return p.paint(colorBool, obj.Inspect())
+	case *object.Null:
+		return p.paint(colorNull, obj.Inspect())
+	case *object.Error:
+		return p.paint(colorError, obj.Inspect())
+	case *object.Array:
+		if visiting[obj] {
+			return "[...]"
+		}
+		visiting = visit(visiting, obj)
+		defer delete(visiting, obj)
+
+		var items []string
+		for i, e := range obj.Elements {
+			if i == maxItems {
+				items = append(items, p.more(len(obj.Elements)-i))
+				break
+			}
+			items = append(items, p.value(e, indent+2, visiting))
+		}
+		return p.list("[", "]", items, indent, true)
+	case *object.Hash:
+		if visiting[obj] {
+			return "{...}"
+		}
+		visiting = visit(visiting, obj)
+		defer delete(visiting, obj)
+
+		var items []string
+		for i, pair := range obj.Pairs() {
+			if i == maxItems {
+				items = append(items, p.more(obj.Len()-i))
+				break
+			}
+			key := p.value(pair.Key, indent+2, visiting)
+			items = append(items, key+": "+p.value(pair.Value, indent+2, visiting))
+		}
+		return p.list("{", "}", items, indent, false)
+	default:
+		return p.paint(colorOther, obj.Inspect())
+	}
+}
+
+// list joins items on a single line if they fit, or else puts each item
+// on its own line. If pack is set and no item spans several lines, as many
+// items as fit are put on each line instead.
+func (p *printer) list(open, close string, items []string, indent int, pack bool) string {
+	inline := open + strings.Join(items, ", ") + close
+	multiline := strings.Contains(inline, "\n")
+	if !multiline && indent+visibleLen(inline) <= printWidth {
+		return inline
+	}
+
+	pad := strings.Repeat(" ", indent+2)
+	var out strings.Builder
+	out.WriteString(open + "\n" + pad)
+	width := indent + 2
+	for i, item := range items {
+		if i > 0 {
+			n := visibleLen(item) + 2
+			if pack && !multiline && width+n <= printWidth {
+				out.WriteString(", ")
+			} else {
+				out.WriteString(",\n" + pad)
+				width = indent + 2
+				n -= 2
+			}
+			width += n
+		} else {
+			width += visibleLen(item)
+		}
+		out.WriteString(item)
+	}
+	out.WriteString("\n" + strings.Repeat(" ", indent) + close)
+	return out.String()
+}
+
+func (p *printer) more(n int) string {
+	return p.paint(colorNull, fmt.Sprintf("... (%d more)", n))
+}
+
+func (p *printer) paint(color, s string) string {
+	if !p.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func visit(visiting map[object.Object]bool, obj object.Object) map[object.Object]bool {
+	if visiting == nil {
+		visiting = make(map[object.Object]bool)
+	}
+	visiting[obj] = true
+	return visiting
+}
+
+// visibleLen returns the number of characters of s without the color
+// escape sequences.
+func visibleLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\x1b' {
+			for i < len(s) && s[i] != 'm' {
+				i++
+			}
+			continue
+		}
+		if utf8.RuneStart(s[i]) {
+			n++
+		}
+	}
+	return n
+}
+
+// quote returns s as a double-quoted string literal that reads back as s.
+func quote(s string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&out, `\x%02x`, s[i])
+		case r == '"' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r == '$' && strings.HasPrefix(s[i+size:], "{"):
+			out.WriteString(`\$`)
+		case r == '\n':
+			out.WriteString(`\n`)
+		case r == '\t':
+			out.WriteString(`\t`)
+		case r == '\r':
+			out.WriteString(`\r`)
+		case r == 0:
+			out.WriteString(`\0`)
+		case !unicode.IsPrint(r):
+			fmt.Fprintf(&out, `\u{%x}`, r)
+		default:
+			out.WriteRune(r)
+		}
+		i += size
+	}
+	out.WriteByte('"')
+	return out.String()
+}
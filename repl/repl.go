@@ -2,47 +2,132 @@ package repl
 
 import (
 	"bufio"
-	"fmt"
 	"io"
+	"os"
 
+	"github.com/lusingander/monkey/ast"
 	"github.com/lusingander/monkey/evaluator"
 	"github.com/lusingander/monkey/lexer"
 	"github.com/lusingander/monkey/object"
 	"github.com/lusingander/monkey/parser"
+	"github.com/peterh/liner"
 )
 
 const prompt = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
-	macroEnv := object.NewEnvironment()
+// Start runs the REPL on a non-interactive input. It returns the code
+// passed to exit, or 0 at the end of the input.
+func Start(in io.Reader, out io.Writer) int {
+	r := &scannerReader{scanner: bufio.NewScanner(in), out: out}
+	return run(r, newSession(out, false))
+}
 
-	for {
-		fmt.Fprintf(out, prompt)
+// StartTerminal runs the REPL on the terminal with a line editor. The
+// history is loaded from historyFile and saved to it at the end, unless
+// historyFile is empty. Results are colored by type if color is set. Like
+// Start, it returns the code passed to exit.
+func StartTerminal(out io.Writer, historyFile string, color bool) (int, error) {
+	s := newSession(out, color)
+	e := newEditor(historyFile, s)
+	code := run(e, s)
+	io.WriteString(out, "\n")
+	return code, e.close()
+}
 
-		if !scanner.Scan() {
-			return
+// IsTerminal reports whether f is a terminal that StartTerminal supports.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0 && liner.TerminalSupported()
+}
+
+// run reads and evaluates entries until the end of the input or a call to
+// exit, and returns the code passed to exit.
+func run(r lineReader, s *session) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			exit, ok := r.(evaluator.Exit)
+			if !ok {
+				panic(r)
+			}
+			code = exit.Code
 		}
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+	}()
 
-		program := p.ParseProgram()
-		if len(p.Errors()) > 0 {
-			printParserErrors(out, p.Errors())
+	for {
+		input, ok := readInput(r, s.out)
+		if !ok {
+			return 0
+		}
+		if isCommand(input) {
+			s.command(input)
 			continue
 		}
+		s.evalSource("", input)
+	}
+}
+
+// session holds the state of a REPL session.
+type session struct {
+	env      *object.Environment
+	macroEnv *object.Environment
+	out      io.Writer
+	printer  *printer
+}
 
-		evaluator.DefineMacros(program, macroEnv)
-		expanded := evaluator.ExpandMacros(program, macroEnv)
+func newSession(out io.Writer, color bool) *session {
+	s := &session{out: out, printer: &printer{color: color}}
+	s.reset()
+	return s
+}
 
-		evaluated := evaluator.Eval(expanded, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
-		}
+func (s *session) reset() {
+	s.env = object.NewEnvironment()
+	s.macroEnv = object.NewEnvironment()
+}
+
+// evalSource evaluates input in the session and prints the result.
+func (s *session) evalSource(filename, input string) {
+	program, ok := s.parse(filename, input)
+	if !ok {
+		return
+	}
+
+	evaluated := eval(program, s.env, s.macroEnv)
+	if evaluated != nil {
+		io.WriteString(s.out, s.printer.format(evaluated))
+		io.WriteString(s.out, "\n")
+	}
+	if errObj, ok := evaluated.(*object.Error); ok {
+		io.WriteString(s.out, errObj.Traceback())
+	}
+}
+
+// parse parses input, printing the errors if there are any.
+func (s *session) parse(filename, input string) (*ast.Program, bool) {
+	p := parser.New(lexer.NewFile(filename, input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		printParserErrors(s.out, p.Errors())
+		return nil, false
 	}
+	return program, true
+}
+
+// eval expands and evaluates program. A Go panic is reported as an error
+// so that it does not end the session, except for a call to exit.
+func eval(program *ast.Program, env, macroEnv *object.Environment) (evaluated object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(evaluator.Exit); ok {
+				panic(r)
+			}
+			evaluated = evaluator.PanicError(r)
+		}
+	}()
+
+	evaluator.DefineMacros(program, macroEnv)
+	expanded := evaluator.ExpandMacros(program, macroEnv)
+	return evaluator.Eval(expanded, env)
 }
 
 func printParserErrors(out io.Writer, errors []string) {
@@ -0,0 +1,268 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lusingander/monkey/object"
+)
+
+func TestStartRecoversFromPanic(t *testing.T) {
+	input := "boom();\n1 + 2;\n"
+	var out bytes.Buffer
+
+	s := newSession(&out, false)
+	s.env.Set("boom", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		var arr []object.Object
+		return arr[len(args)]
+	}})
+	run(&scannerReader{scanner: bufio.NewScanner(strings.NewReader(input)), out: &out}, s)
+
+	if !strings.Contains(out.String(), "ERROR: internal error: runtime error: index out of range") {
+		t.Errorf("panic not reported as an error: got=%q", out.String())
+	}
+	if !strings.Contains(out.String(), "\n>> 3\n") {
+		t.Errorf("session did not continue after the panic: got=%q", out.String())
+	}
+}
+
+func TestStartExit(t *testing.T) {
+	var out bytes.Buffer
+	code := Start(strings.NewReader("1\nexit(4)\n2\n"), &out)
+	if code != 4 {
+		t.Errorf("wrong exit code: want=4, got=%d", code)
+	}
+	expected := ">> 1\n>> "
+	if out.String() != expected {
+		t.Errorf("wrong output: want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2;", false},
+		{"", false},
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\n  x\n}", false},
+		{"puts(1,", true},
+		{"[1, [2,", true},
+		{"[1, [2]]", false},
+		{"let h = {", true},
+		{"}", false},
+		{"let s = `abc", true},
+		{"let s = `abc\ndef`", false},
+		{`"abc`, false},
+		{`"a${f(`, true},
+		{`"a${f(1)}b"`, false},
+		{"fn() { \"{\" }", false},
+	}
+
+	for _, tt := range tests {
+		if actual := incomplete(tt.input); actual != tt.expected {
+			t.Errorf("incomplete(%q): want=%t, got=%t", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestStartMultiLine(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let f = fn(x) {\n  let y = x * 2;\n\n  y + 1\n};\nf(3);\n",
+			">> .. .. .. .. >> 7\n>> ",
+		},
+		{
+			"let s = `a\nb`;\ns;\n",
+			">> .. >> \"a\\nb\"\n>> ",
+		},
+		{
+			"let f = fn() {\n\n\n1 + 2;\n",
+			">> .. .. (input discarded)\n>> 3\n>> ",
+		},
+		{
+			"[1,\n",
+			">> .. parser errors:\n\t1:4: no prefix parse function for EOF found\n\t1:5: expected next token to be ], got EOF instead\n>> ",
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out)
+		if out.String() != tt.expected {
+			t.Errorf("wrong output for %q: want=%q, got=%q", tt.input, tt.expected, out.String())
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("counter", &object.Integer{Value: 1})
+	env.Set("contents", &object.Integer{Value: 2})
+	inner := object.NewEnclosedEnvironment(env)
+	inner.Set("réponse", &object.Integer{Value: 3})
+
+	tests := []struct {
+		line        string
+		pos         int
+		head        string
+		completions []string
+		tail        string
+	}{
+		{"co", 2, "", []string{"concat", "contains", "contents", "continue", "counter"}, ""},
+		{"let x = cou", 11, "let x = ", []string{"counter"}, ""},
+		{"f(pu, 1)", 4, "f(", []string{"push", "puts"}, ", 1)"},
+		{"ré + 1", 2, "", []string{"réponse"}, " + 1"},
+		{"le", 2, "", []string{"len", "let"}, ""},
+		{"1 + ", 4, "1 + ", nil, ""},
+		{"xyz", 3, "", nil, ""},
+	}
+
+	for _, tt := range tests {
+		head, completions, tail := complete(tt.line, tt.pos, inner)
+		if head != tt.head || tail != tt.tail || !reflect.DeepEqual(completions, tt.completions) {
+			t.Errorf("complete(%q, %d): want=(%q, %q, %q), got=(%q, %q, %q)",
+				tt.line, tt.pos, tt.head, tt.completions, tt.tail, head, completions, tail)
+		}
+	}
+}
+
+// fakeReader returns its lines in turn and then io.EOF. A nil error in
+// errs means the line was read.
+type fakeReader struct {
+	lines []string
+	errs  []error
+}
+
+func (r *fakeReader) readLine(prompt string) (string, error) {
+	if len(r.lines) == 0 {
+		return "", io.EOF
+	}
+	line, err := r.lines[0], r.errs[0]
+	r.lines, r.errs = r.lines[1:], r.errs[1:]
+	return line, err
+}
+
+func TestReadInputAbort(t *testing.T) {
+	r := &fakeReader{
+		lines: []string{"let f = fn() {", "", "1 + 2"},
+		errs:  []error{nil, errAborted, nil},
+	}
+	var out bytes.Buffer
+
+	input, ok := readInput(r, &out)
+	if !ok || input != "1 + 2" {
+		t.Errorf("wrong input after abort: got=%q, %t", input, ok)
+	}
+	if _, ok := readInput(r, &out); ok {
+		t.Errorf("input read after the end")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lib.monkey")
+	if err := ioutil.WriteFile(file, []byte("let double = fn(x) { x * 2 };\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":tokens let x = 1;", "1:1\tLET\t\"let\"\n1:5\tIDENT\t\"x\"\n1:7\t=\t\"=\"\n1:9\tINT\t\"1\"\n1:10\t;\t\";\"\n"},
+		{`:tokens "a\q"`, "1:1\tILLEGAL\t\"\\\"a\\\\q\"\tunknown escape sequence: \\q\n"},
+		{":ast -x", "Program\n  Statements:\n    ExpressionStatement\n      Expression: PrefixExpression\n        Operator: \"-\"\n        Right: Identifier\n          Value: \"x\"\n"},
+		{":ast {1: true}", "Program\n  Statements:\n    ExpressionStatement\n      Expression: HashLiteral\n        Key: IntegerLiteral\n          Value: 1\n        Value: Boolean\n          Value: true\n"},
+		{":ast let = 1", "parser errors:\n\t1:5: expected next token to be IDENT, got = instead\n\t1:5: no prefix parse function for = found\n"},
+		{":expand let m = macro(a) { quote(unquote(a) + 1) }; m(2 * 3)", "((2 * 3) + 1)\n"},
+		{":type 1.5", "FLOAT\n"},
+		{`:type [1, "a"]`, "ARRAY\n"},
+		{":type let x = 1", "no value\n"},
+		{":type x", "ERROR: 1:1: identifier not found: x\n"},
+		{"let a = 1;\nlet m = macro() { quote(1) };\n:env", "bindings:\n  a = 1\nmacros:\n  m = macro() {\nquote(1)}\n\n"},
+		{":env", "bindings:\n  (none)\nmacros:\n  (none)\n"},
+		{"let a = 1;\n:reset\na", "ERROR: 1:1: identifier not found: a\n"},
+		{":load " + file + "\ndouble(4)", "8\n"},
+		{":load", "usage: :load FILE\n"},
+		{":nope", "unknown command: :nope (try :help)\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out)
+
+		// keep the output of the last input only
+		outputs := strings.Split(out.String(), prompt)
+		actual := outputs[len(outputs)-2]
+		if actual != tt.expected {
+			t.Errorf("wrong output for %q: want=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"5"`, "\"5\"\n"},
+		{"5", "5\n"},
+		{`"a\n\t\"b\" \\ \${c}"`, "\"a\\n\\t\\\"b\\\" \\\\ \\${c}\"\n"},
+		{`[1, "a", true, puts(), 1.5]`, "[1, \"a\", true, null, 1.5]\n"},
+		{`{"a": [1, 2], [3]: {}}`, "{\"a\": [1, 2], [3]: {}}\n"},
+		{"range(30)", "[\n  0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21,\n  22, 23, 24, 25, 26, 27, 28, 29\n]\n"},
+		{`[range(25), {"name": "a long enough name", "more": "to get well past the width of the line"}]`,
+			"[\n  [\n    0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21,\n    22, 23, 24\n  ],\n  {\n    \"name\": \"a long enough name\",\n    \"more\": \"to get well past the width of the line\"\n  }\n]\n"},
+		{"let a = [1]; a[0] = a; a", "[[...]]\n"},
+		{"1 / 0", "ERROR: 1:1: division by zero: 1 / 0\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out)
+
+		outputs := strings.Split(out.String(), prompt)
+		actual := outputs[len(outputs)-2]
+		if actual != tt.expected {
+			t.Errorf("wrong output for %q: want=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestPrinterTruncates(t *testing.T) {
+	elements := make([]object.Object, 150)
+	for i := range elements {
+		elements[i] = &object.Integer{Value: 1}
+	}
+	p := &printer{}
+	actual := p.format(&object.Array{Elements: elements})
+	if !strings.HasSuffix(actual, ",\n  ... (50 more)\n]") {
+		t.Errorf("array not truncated: %q", actual)
+	}
+	if n := strings.Count(actual, "1"); n != 100 {
+		t.Errorf("wrong number of elements printed: want=100, got=%d", n)
+	}
+}
+
+func TestPrinterColor(t *testing.T) {
+	p := &printer{color: true}
+	obj := &object.Array{Elements: []object.Object{
+		&object.Integer{Value: 1},
+		&object.String{Value: "a"},
+		&object.Error{Message: "oops"},
+	}}
+	expected := "[\x1b[33m1\x1b[0m, \x1b[32m\"a\"\x1b[0m, \x1b[1;31mERROR: oops\x1b[0m]"
+	if actual := p.format(obj); actual != expected {
+		t.Errorf("wrong output: want=%q, got=%q", expected, actual)
+	}
+}
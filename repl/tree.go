@@ -0,0 +1,65 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/lusingander/monkey/ast"
+	"github.com/lusingander/monkey/token"
+)
+
+var (
+	nodeType     = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	tokenType    = reflect.TypeOf(token.Token{})
+	positionType = reflect.TypeOf(token.Position{})
+)
+
+// printTree writes node as an indented tree with one line per node and per
+// field. Tokens and positions are left out, as are nil fields.
+func printTree(out io.Writer, node ast.Node) {
+	printValue(out, "", reflect.ValueOf(node), 0)
+}
+
+func printValue(out io.Writer, label string, v reflect.Value, depth int) {
+	if v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+	}
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	indent := strings.Repeat("  ", depth)
+
+	switch {
+	case v.Type() == tokenType || v.Type() == positionType:
+	case v.Type().Implements(nodeType):
+		fmt.Fprintf(out, "%s%s%s\n", indent, label, v.Elem().Type().Name())
+		if hash, ok := v.Interface().(*ast.HashLiteral); ok {
+			// Pairs is a map, so print it in the order of Keys
+			for _, key := range hash.Keys {
+				printValue(out, "Key: ", reflect.ValueOf(key), depth+1)
+				printValue(out, "Value: ", reflect.ValueOf(hash.Pairs[key]), depth+1)
+			}
+			return
+		}
+		s := v.Elem()
+		for i := 0; i < s.NumField(); i++ {
+			printValue(out, s.Type().Field(i).Name+": ", s.Field(i), depth+1)
+		}
+	case v.Kind() == reflect.Slice:
+		if v.Len() == 0 {
+			return
+		}
+		fmt.Fprintf(out, "%s%s\n", indent, strings.TrimSuffix(label, " "))
+		for i := 0; i < v.Len(); i++ {
+			printValue(out, "", v.Index(i), depth+1)
+		}
+	case v.Kind() == reflect.String:
+		fmt.Fprintf(out, "%s%s%q\n", indent, label, v.String())
+	default:
+		fmt.Fprintf(out, "%s%s%v\n", indent, label, v.Interface())
+	}
+}
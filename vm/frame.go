@@ -0,0 +1,37 @@
+package vm
+
+import (
+	"github.com/lusingander/monkey/code"
+	"github.com/lusingander/monkey/object"
+	"github.com/lusingander/monkey/token"
+)
+
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{
+		cl:          cl,
+		ip:          -1,
+		basePointer: basePointer,
+	}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// Pos returns the source position of the instruction being executed.
+func (f *Frame) Pos() token.Position {
+	return f.cl.Fn.Positions.Lookup(f.ip)
+}
+
+func (f *Frame) name() string {
+	if f.cl.Fn.Name == "" {
+		return "<anonymous>"
+	}
+	return f.cl.Fn.Name
+}
@@ -0,0 +1,35 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/lusingander/monkey/object"
+)
+
+// iterator runs through the elements of an array in a for-in loop. Like
+// in the evaluator, it iterates over a copy, so that the loop body cannot
+// affect the iteration.
+type iterator struct {
+	elements []object.Object
+	index    int
+}
+
+func newIterator(obj object.Object) object.Object {
+	array, ok := obj.(*object.Array)
+	if !ok {
+		return &object.Error{Message: fmt.Sprintf("cannot iterate over %s", obj.Type())}
+	}
+	return &iterator{elements: append([]object.Object(nil), array.Elements...)}
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+// next returns the next element, or false if there is none left.
+func (it *iterator) next() (object.Object, bool) {
+	if it.index >= len(it.elements) {
+		return nil, false
+	}
+	it.index++
+	return it.elements[it.index-1], true
+}
@@ -0,0 +1,472 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/lusingander/monkey/code"
+	"github.com/lusingander/monkey/compiler"
+	"github.com/lusingander/monkey/evaluator"
+	"github.com/lusingander/monkey/object"
+)
+
+const (
+	StackSize   = 2048
+	GlobalsSize = 65536
+	MaxFrames   = 1024
+)
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLessThan:     "<",
+	code.OpGreaterThan:  ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+}
+
+type VM struct {
+	constants []object.Object
+	builtins  []*object.Builtin
+
+	stack []object.Object
+	sp    int // stack[sp-1] is the top of the stack
+
+	globals []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	// openUpvalues are the upvalues still referring to stack slots,
+	// ordered by the frame they belong to.
+	openUpvalues []openUpvalue
+
+	result object.Object // value of a top-level return statement
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	builtins := []*object.Builtin{}
+	for _, name := range evaluator.BuiltinNames() {
+		builtin, _ := evaluator.LookupBuiltin(name)
+		builtins = append(builtins, builtin)
+	}
+
+	return &VM{
+		constants:   bytecode.Constants,
+		builtins:    builtins,
+		stack:       make([]object.Object, StackSize),
+		sp:          0,
+		globals:     make([]object.Object, GlobalsSize),
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// NewWithGlobals returns a VM that uses s as its globals store.
+func NewWithGlobals(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// LastPoppedStackElem returns the value of the last expression statement.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+// Run executes the bytecode and returns the value of the program,
+// or an *object.Error if a runtime error occurs, just like evaluator.Eval.
+func (vm *VM) Run() object.Object {
+	if err := vm.run(1); err != nil {
+		return err
+	}
+	if vm.result != nil {
+		return vm.result
+	}
+	return vm.LastPoppedStackElem()
+}
+
+// run executes instructions until the frame at depth returns, or until the
+// main function ends for depth 1.
+func (vm *VM) run(depth int) *object.Error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		var err *object.Error
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.push(vm.constants[constIndex])
+		case code.OpPop:
+			vm.pop()
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+			code.OpEqual, code.OpNotEqual,
+			code.OpLessThan, code.OpGreaterThan, code.OpLessEqual, code.OpGreaterEqual:
+			right := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.EvalInfixExpression(infixOperators[op], left, right))
+		case code.OpMinus:
+			err = vm.pushResult(evaluator.EvalPrefixExpression("-", vm.pop()))
+		case code.OpBang:
+			err = vm.pushResult(evaluator.EvalPrefixExpression("!", vm.pop()))
+		case code.OpBitNot:
+			err = vm.pushResult(evaluator.EvalPrefixExpression("~", vm.pop()))
+		case code.OpTrue:
+			err = vm.push(evaluator.TRUE)
+		case code.OpFalse:
+			err = vm.push(evaluator.FALSE)
+		case code.OpNull:
+			err = vm.push(evaluator.NULL)
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			condition := vm.pop()
+			if !evaluator.IsTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpIter:
+			err = vm.pushResult(newIterator(vm.pop()))
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			if value, ok := vm.pop().(*iterator).next(); ok {
+				err = vm.push(value)
+			} else {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			vm.globals[globalIndex] = vm.pop()
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.push(vm.globals[globalIndex])
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+			frame := vm.currentFrame()
+			err = vm.push(vm.stack[frame.basePointer+int(localIndex)])
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+			err = vm.push(vm.builtins[builtinIndex])
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+			err = vm.push(array)
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			hash := vm.buildHash(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+			err = vm.pushResult(hash)
+		case code.OpInterpolate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			parts := make([]object.Object, numParts)
+			copy(parts, vm.stack[vm.sp-numParts:vm.sp])
+			vm.sp = vm.sp - numParts
+			err = vm.push(evaluator.Interpolate(parts))
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.EvalIndexExpression(left, index))
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.EvalIndexAssignment(left, index, value))
+		case code.OpDup2:
+			if err = vm.push(vm.stack[vm.sp-2]); err == nil {
+				err = vm.push(vm.stack[vm.sp-2])
+			}
+		case code.OpSlice:
+			high := vm.pop()
+			low := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.EvalSliceExpression(left, low, high))
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+			err = vm.executeCall(int(numArgs))
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.framesIndex == 1 {
+				vm.result = returnValue
+				return nil
+			}
+			frame := vm.popFrame()
+			vm.closeUpvalues(frame.basePointer)
+			vm.sp = frame.basePointer - 1
+			err = vm.push(returnValue)
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.closeUpvalues(frame.basePointer)
+			vm.sp = frame.basePointer - 1
+			err = vm.push(evaluator.NULL)
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.pushClosure(int(constIndex))
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+			err = vm.push(vm.currentFrame().cl.Free[freeIndex].Get())
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+			vm.currentFrame().cl.Free[freeIndex].Set(vm.pop())
+		case code.OpCurrentClosure:
+			err = vm.push(vm.currentFrame().cl)
+		default:
+			err = vm.newError("unknown opcode: %d", op)
+		}
+
+		if err != nil {
+			return vm.fail(err)
+		}
+		if vm.framesIndex < depth {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) *object.Error {
+	if vm.framesIndex >= MaxFrames {
+		return vm.newError("stack overflow")
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func (vm *VM) push(o object.Object) *object.Error {
+	if vm.sp >= StackSize {
+		return vm.newError("stack overflow")
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+	return nil
+}
+
+// pushResult pushes the result of an operation, which may be an error.
+func (vm *VM) pushResult(o object.Object) *object.Error {
+	if err, ok := o.(*object.Error); ok {
+		return err
+	}
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) object.Object {
+	hash := &object.Hash{}
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+		hashKey, ok := object.ToHashable(key)
+		if !ok {
+			return vm.newError("unusable as hash key: %s", key.Type())
+		}
+		hash.Set(hashKey, value)
+	}
+	return hash
+}
+
+func (vm *VM) executeCall(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return vm.newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) *object.Error {
+	if numArgs != cl.Fn.NumParameters {
+		return vm.newError("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	if vm.sp >= StackSize {
+		return vm.newError("stack overflow")
+	}
+	return nil
+}
+
+func (vm *VM) pushClosure(constIndex int) *object.Error {
+	fn, ok := vm.constants[constIndex].(*object.CompiledFunction)
+	if !ok {
+		return vm.newError("not a function: %+v", vm.constants[constIndex])
+	}
+
+	frame := vm.currentFrame()
+	free := make([]*object.Upvalue, len(fn.Captures))
+	for i, c := range fn.Captures {
+		switch c.Kind {
+		case object.CaptureLocal:
+			free[i] = vm.captureUpvalue(frame.basePointer + c.Index)
+		case object.CaptureFree:
+			free[i] = frame.cl.Free[c.Index]
+		case object.CaptureSelf:
+			free[i] = object.NewClosedUpvalue(frame.cl)
+		}
+	}
+	return vm.push(&object.Closure{Fn: fn, Free: free})
+}
+
+type openUpvalue struct {
+	slot    int
+	upvalue *object.Upvalue
+}
+
+// captureUpvalue returns the upvalue referring to the stack slot,
+// so that all closures capturing the same variable share it.
+func (vm *VM) captureUpvalue(slot int) *object.Upvalue {
+	for i := len(vm.openUpvalues) - 1; i >= 0; i-- {
+		if vm.openUpvalues[i].slot == slot {
+			return vm.openUpvalues[i].upvalue
+		}
+	}
+	upvalue := &object.Upvalue{Location: &vm.stack[slot]}
+	vm.openUpvalues = append(vm.openUpvalues, openUpvalue{slot: slot, upvalue: upvalue})
+	return upvalue
+}
+
+// closeUpvalues closes the upvalues of the stack slots above basePointer,
+// which are about to be discarded.
+func (vm *VM) closeUpvalues(basePointer int) {
+	n := len(vm.openUpvalues)
+	for n > 0 && vm.openUpvalues[n-1].slot >= basePointer {
+		vm.openUpvalues[n-1].upvalue.Close()
+		n--
+	}
+	vm.openUpvalues = vm.openUpvalues[:n]
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) *object.Error {
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+	var result object.Object
+	if builtin.CallbackFn != nil {
+		result = builtin.CallbackFn(vm.call, args...)
+	} else {
+		result = builtin.Fn(args...)
+	}
+	vm.sp = vm.sp - numArgs - 1
+	return vm.pushResult(result)
+}
+
+// call calls fn from within a builtin, running the vm until fn returns.
+func (vm *VM) call(fn object.Object, args ...object.Object) object.Object {
+	sp := vm.sp
+	if err := vm.push(fn); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return err
+		}
+	}
+	if err := vm.executeCall(len(args)); err != nil {
+		return err
+	}
+	if _, ok := fn.(*object.Closure); ok {
+		if err := vm.run(vm.framesIndex); err != nil {
+			return err
+		}
+	}
+	result := vm.pop()
+	vm.sp = sp
+	return result
+}
+
+func (vm *VM) newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// fail completes err with the position of the failing instruction
+// and the current call stack.
+func (vm *VM) fail(err *object.Error) *object.Error {
+	if !err.Pos.IsValid() {
+		err.Pos = vm.currentFrame().Pos()
+	}
+	err.Trace = nil
+	for i := 1; i < vm.framesIndex; i++ {
+		err.Trace = append(err.Trace, object.Frame{
+			Function: vm.frames[i].name(),
+			CallSite: vm.frames[i-1].Pos(),
+		})
+	}
+	return err
+}
@@ -0,0 +1,168 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/lusingander/monkey/compiler"
+	"github.com/lusingander/monkey/lexer"
+	"github.com/lusingander/monkey/object"
+	"github.com/lusingander/monkey/parser"
+)
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let f = fn(a) { a }; f(1, 2);",
+			"ERROR: test.monkey:1:22: wrong number of arguments: want=1, got=2",
+		},
+		{
+			"let f = fn() { f() }; f();",
+			"ERROR: test.monkey:1:16: stack overflow",
+		},
+		{
+			"1();",
+			"ERROR: test.monkey:1:1: not a function: INTEGER",
+		},
+	}
+
+	for _, tt := range tests {
+		result := run(t, tt.input)
+
+		errObj, ok := result.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned: got=%T (%+v)", result, result)
+			continue
+		}
+		if errObj.Inspect() != tt.expected {
+			t.Errorf("wrong error: want=%q, got=%q", tt.expected, errObj.Inspect())
+		}
+	}
+}
+
+func TestGlobalsStore(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	globals := make([]object.Object, GlobalsSize)
+	globals[symbolTable.Define("x").Index] = &object.Integer{Value: 40}
+
+	program := parser.New(lexer.NewFile("test.monkey", "let y = x + 2; y")).ParseProgram()
+	c := compiler.NewWithState(symbolTable, []object.Object{})
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	result := NewWithGlobals(c.Bytecode(), globals).Run()
+
+	integer, ok := result.(*object.Integer)
+	if !ok || integer.Value != 42 {
+		t.Errorf("wrong result: want=42, got=%+v", result)
+	}
+	if y, ok := globals[1].(*object.Integer); !ok || y.Value != 42 {
+		t.Errorf("global not stored: got=%+v", globals[1])
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	input := `
+let fib = fn(n) {
+  if (n < 2) { return n; }
+  fib(n - 1) + fib(n - 2)
+};
+fib(15);
+`
+	result := run(t, input)
+
+	integer, ok := result.(*object.Integer)
+	if !ok {
+		t.Fatalf("object is not Integer: got=%T (%+v)", result, result)
+	}
+	if integer.Value != 610 {
+		t.Errorf("object has wrong value: want=%d, got=%d", 610, integer.Value)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			`
+let newAdderOuter = fn(a, b) {
+  let c = a + b;
+  fn(d) {
+    let e = d + c;
+    fn(f) { e + f; };
+  };
+};
+let newAdderInner = newAdderOuter(1, 2);
+let adder = newAdderInner(3);
+adder(8);
+`,
+			14,
+		},
+		{
+			// the closure sees the variable, not a copy of its value
+			`
+let f = fn() {
+  let x = 1;
+  let g = fn() { x };
+  let x = 2;
+  g
+};
+f()();
+`,
+			2,
+		},
+		{
+			// closures created by the same call share the captured variable
+			`
+let pair = fn(x) {
+  [fn() { x }, fn() { x * 10 }]
+};
+let p = pair(4);
+p[0]() + p[1]();
+`,
+			44,
+		},
+		{
+			`
+let wrapper = fn() {
+  let countDown = fn(x) {
+    if (x == 0) { return 0; }
+    countDown(x - 1);
+  };
+  countDown(10);
+};
+wrapper();
+`,
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		result := run(t, tt.input)
+
+		integer, ok := result.(*object.Integer)
+		if !ok {
+			t.Errorf("object is not Integer: got=%T (%+v)", result, result)
+			continue
+		}
+		if integer.Value != tt.expected {
+			t.Errorf("object has wrong value: want=%d, got=%d", tt.expected, integer.Value)
+		}
+	}
+}
+
+func run(t *testing.T, input string) object.Object {
+	l := lexer.NewFile("test.monkey", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return New(c.Bytecode()).Run()
+}